@@ -2,34 +2,36 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/DaoCasino/casino-backend/election"
 	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/DaoCasino/casino-backend/offsetstore"
 
-	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/DaoCasino/casino-backend/tracing"
+	"github.com/DaoCasino/casino-backend/utils/retry"
 	broker "github.com/DaoCasino/platform-action-monitor-client"
 	"github.com/eoscanada/eos-go"
 	"github.com/eoscanada/eos-go/ecc"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
-	"github.com/zenazn/goji/graceful"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	GetInfoCacheTTL      = 1   // seconds
 	EosInternalErrorCode = 500 // internal error HTTP code
-	// see: https://github.com/DaoCasino/DAObet/blob/master/libraries/chain/include/eosio/chain/exceptions.hpp
-	EosInternalDuplicateErrorCode = 3040008
-	ServiceName                   = "casino"
+	ServiceName          = "casino"
 )
 
 type ResponseWriter = http.ResponseWriter
@@ -37,8 +39,11 @@ type Request = http.Request
 type JSONResponse = map[string]interface{}
 
 type BrokerConfig struct {
-	TopicID     broker.EventType
-	TopicOffset uint64
+	TopicID         broker.EventType
+	TopicOffset     uint64
+	TopicOffsetPath string
+	// replay topic from the start if offset is corrupted
+	IgnoreCorruptedOffset bool
 }
 
 type PubKeys struct {
@@ -51,31 +56,66 @@ type BlockChainConfig struct {
 	SignerAccountName   eos.AccountName
 	CasinoAccountName   eos.AccountName
 	EosPubKeys          PubKeys
-	RSAKey              *rsa.PrivateKey
+	RSAKeyring          *RSAKeyring
 	PlatformAccountName eos.AccountName
 	PlatformPubKey      ecc.PublicKey
+	GameContracts       []eos.AccountName
 }
 
 type HTTPConfig struct {
-	RetryAmount int
-	RetryDelay  time.Duration
-	Timeout     time.Duration
+	RetryAmount   int
+	RetryDelay    time.Duration // delay after the first failed attempt, doubled after every next one
+	Timeout       time.Duration // per attempt
+	MaxRetryDelay time.Duration // 0 means unlimited
+	RetryJitter   float64       // fraction of delay randomized to spread retries of concurrent pushes
+}
+
+func (c *HTTPConfig) retryPolicy() retry.Policy {
+	return retry.Policy{
+		Attempts:       c.RetryAmount,
+		InitialDelay:   c.RetryDelay,
+		MaxDelay:       c.MaxRetryDelay,
+		Jitter:         c.RetryJitter,
+		AttemptTimeout: c.Timeout,
+	}
 }
 
 type AppConfig struct {
 	Broker     BrokerConfig
 	BlockChain BlockChainConfig
 	HTTP       HTTPConfig
+	Snapshots  SnapshotsConfig
+	ChainState ChainStateConfig
+	SelfCheck  SelfCheckConfig
+	RSAKeys    RSAKeysConfig
+	Fairness   FairnessConfig
+	Tracing    TracingConfig
+	Health     HealthConfig
+	Shutdown   ShutdownConfig
+	Fallback   FallbackConfig
 }
 
 type App struct {
-	bcAPI            *eos.API
-	lastGetInfoStamp time.Time
-	lastGetInfoLock  sync.Mutex
-	lastCachedInfo   *eos.InfoResp
+	bcAPI            BlockchainAPI
+	ChainTracker     *ChainTracker
 	BrokerClient     EventListener
-	OffsetHandler    utils.FileStorage
+	Offsets          offsetstore.OffsetStore
 	EventMessages    chan *broker.EventMessage
+	SnapshotStorage  *SnapshotStorage
+	SignidiceStorage *SignidiceStorage
+	SelfCheckReport  SelfCheckReport
+	RSAPubKeys       *RSAPubKeyCache
+	Tracer           *sdktrace.TracerProvider
+	Signer           *RoleSigner
+	Elector          *election.Elector // only leader processes events if set
+	Failover         *FailoverListener // set if chain polling fallback is enabled
+	health           *healthState
+	inFlight         inFlight
+	stop             chan struct{}
+	stopOnce         sync.Once
+	work             context.Context // cancelled once shutdown timeout is over to abort retries of in-flight events
+	cancelWork       context.CancelFunc
+	offsetLock       sync.Mutex
 	*AppConfig
 }
 
@@ -86,164 +126,281 @@ type EventListener interface {
 	Run(ctx context.Context)
 }
 
-func NewApp(bcAPI *eos.API, brokerClient EventListener, eventMessages chan *broker.EventMessage,
-	offsetHandler utils.FileStorage,
-	cfg *AppConfig) *App {
-	return &App{bcAPI: bcAPI, BrokerClient: brokerClient, OffsetHandler: offsetHandler,
-		EventMessages: eventMessages, AppConfig: cfg}
+// BlockchainAPI is a set of node calls and signing used by the service
+type BlockchainAPI interface {
+	GetInfo() (*eos.InfoResp, error)
+	GetInfoContext(ctx context.Context) (*eos.InfoResp, error)
+	PushTransaction(tx *eos.PackedTransaction) (*eos.PushTransactionFullResp, error)
+	PushTransactionContext(ctx context.Context, tx *eos.PackedTransaction) (*eos.PushTransactionFullResp, error)
+	GetTableRows(params eos.GetTableRowsRequest) (*eos.GetTableRowsResp, error)
+	GetBlockByNum(num uint32) (*eos.BlockResp, error)
+	GetAccount(name eos.AccountName) (*eos.AccountResp, error)
+	GetCurrencyBalance(account eos.AccountName, symbol string, code eos.AccountName) ([]eos.Asset, error)
+	Sign(tx *eos.SignedTransaction, chainID []byte, requiredKeys ...ecc.PublicKey) (*eos.SignedTransaction, error)
 }
 
-func (app *App) getTxOpts() (*eos.TxOptions, error) {
-	app.lastGetInfoLock.Lock()
-	defer app.lastGetInfoLock.Unlock()
-
-	var info *eos.InfoResp
-
-	if !app.lastGetInfoStamp.IsZero() && time.Now().Add(-GetInfoCacheTTL*time.Second).Before(app.lastGetInfoStamp) {
-		info = app.lastCachedInfo
-	} else {
-		var err error
-		info, err = app.bcAPI.GetInfo()
-		if err != nil {
-			return nil, err
-		}
-		app.lastGetInfoStamp = time.Now()
-		app.lastCachedInfo = info
-	}
-
-	return &eos.TxOptions{
-		ChainID:     info.ChainID,
-		HeadBlockID: info.LastIrreversibleBlockID, // set lib as TAPOS block reference
-	}, nil
+func NewApp(bcAPI BlockchainAPI, brokerClient EventListener, eventMessages chan *broker.EventMessage,
+	offsets offsetstore.OffsetStore,
+	cfg *AppConfig) *App {
+	app := &App{bcAPI: bcAPI, ChainTracker: NewChainTracker(bcAPI, &cfg.ChainState),
+		BrokerClient: brokerClient, Offsets: offsets,
+		EventMessages: eventMessages, AppConfig: cfg, health: newHealthState(),
+		stop: make(chan struct{})}
+	app.RSAPubKeys = NewRSAPubKeyCache(cfg.RSAKeys.PubKeyCacheTTL, app.getContractEncodedRSAPubKey)
+	app.work, app.cancelWork = context.WithCancel(context.Background())
+	return app
 }
 
-func (app *App) processEvent(event *broker.Event) *string {
-	log.Debug().Msgf("Processing event %+v", event)
+func (app *App) processEvent(ctx context.Context, event *broker.Event) *string {
+	ctx, eventSpan := tracing.Start(ctx, "signidice_part_2")
+	eventSpan.SetAttribute(FieldSessionID, event.RequestID)
+	eventSpan.SetAttribute(FieldSender, event.Sender)
+	defer eventSpan.End()
+	logger := withTrace(log.With().Uint64(FieldSessionID, event.RequestID).Str(FieldSender, event.Sender).Logger(),
+		eventSpan)
+	logger.Debug().Uint64("offset", event.Offset).RawJSON("data", event.Data).Msg("Processing event")
 	start := time.Now()
+	failure := "" // reason label of failed event
 	defer func() {
 		elapsed := time.Since(start)
 		metrics.SigniDiceProcessingTimeMs.Observe(elapsed.Seconds() * 1000)
+		metrics.SigniDiceEvents.WithLabelValues(metrics.Outcome(failure), failure).Inc()
+		metrics.MarkEventProcessed()
+		app.health.markEventProcessed()
+		if failure != "" {
+			eventSpan.SetAttribute("failure", failure)
+		}
 	}()
 	var data struct {
 		Digest eos.Checksum256 `json:"digest"`
 	}
+	_, span := tracing.Start(ctx, "parse_digest")
 	parseError := json.Unmarshal(event.Data, &data)
+	span.SetError(parseError)
+	span.End()
 	if parseError != nil {
-		log.Error().Msgf("Couldnt get digest from event, "+
-			"sessionID: %d, reason: %s", event.RequestID, parseError.Error())
+		logger.Error().Err(parseError).Msg("Couldnt get digest from event")
+		failure = "parse_digest"
 		return nil
 	}
 
 	api := app.bcAPI
-	signature, signError := utils.RsaSign(data.Digest, app.BlockChain.RSAKey)
-
+	game := eos.AN(event.Sender)
+	_, span = tracing.Start(ctx, "rsa_sign")
+	signature, signError := app.signDigest(&logger, game, data.Digest)
+	span.SetError(signError)
+	span.End()
 	if signError != nil {
-		log.Error().Msgf("Couldnt sign signidice_part_2, "+
-			"sessionID: %d, reason: %s", event.RequestID, signError.Error())
+		logger.Error().Err(signError).Msg("Couldnt sign signidice_part_2")
+		failure = "rsa_sign"
 		return nil
 	}
 
-	var txOpts *eos.TxOptions
-	err := utils.RetryWithTimeout(func() error {
-		var e error
-		txOpts, e = app.getTxOpts()
-		return e
-	}, app.HTTP.RetryAmount, app.HTTP.Timeout, app.HTTP.RetryDelay)
+	// fail fast if chain state is stale, retrying wouldn't help
+	_, span = tracing.Start(ctx, "get_tx_opts")
+	txOpts, err := app.ChainTracker.TxOpts()
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		log.Error().Msgf("Failed to get blockchain state, "+
-			"sessionID: %d, reason: %s", event.RequestID, err.Error())
+		logger.Error().Err(err).Msg("Failed to get blockchain state")
+		failure = "chain_state"
 		return nil
 	}
 
-	packedTrx, err := GetSigndiceTransaction(api, eos.AN(event.Sender), app.BlockChain.SignerAccountName,
-		event.RequestID, signature, app.BlockChain.EosPubKeys.SigniDice, txOpts)
+	buildTrx := func(txOpts *eos.TxOptions) (_ *eos.PackedTransaction, _ string, err error) {
+		_, span := tracing.Start(ctx, "build_trx")
+		defer func() {
+			span.SetError(err)
+			span.End()
+		}()
+		packedTrx, err := GetSigndiceTransaction(api, game, app.BlockChain.SignerAccountName,
+			event.RequestID, signature.Signature, signature.Scheme, app.BlockChain.EosPubKeys.SigniDice, txOpts)
+		if err != nil {
+			return nil, "", err
+		}
+		trxID, err := packedTrx.ID()
+		if err != nil {
+			return nil, "", err
+		}
+		return packedTrx, trxID.String(), nil
+	}
 
+	packedTrx, trxHexEncoded, err := buildTrx(txOpts)
 	if err != nil {
-		log.Error().Msgf("Couldn't form signidice_part_2 trx, "+
-			"sessionID: %d, reason: %s", event.RequestID, err.Error())
+		logger.Error().Err(err).Msg("Couldn't form signidice_part_2 trx")
+		failure = "build_trx"
 		return nil
 	}
 
-	trxID, err := packedTrx.ID()
-	if err != nil {
-		log.Warn().Msgf("failed to calc trx ID, reason: %s", err.Error())
-		return nil
+	// rebuild expired trx with fresh TAPOS
+	rebuild := func() (*eos.PackedTransaction, string, error) {
+		if err := app.ChainTracker.Refresh(); err != nil {
+			return nil, "", err
+		}
+		txOpts, err := app.ChainTracker.TxOpts()
+		if err != nil {
+			return nil, "", err
+		}
+		return buildTrx(txOpts)
 	}
-	trxHexEncoded := trxID.String()
-	if sendError := SendPackedTrxWithRetries(app.bcAPI, packedTrx, trxHexEncoded,
-		app.HTTP.RetryAmount, app.HTTP.Timeout, app.HTTP.RetryDelay); sendError != nil {
-		log.Error().Msgf("Failed to send signidice_part_2 trx, "+
-			"sessionID: %d, reason: %s", event.RequestID, sendError.Error())
+
+	trxHexEncoded, sendError := SendPackedTrxWithRetries(ctx, &logger, app.bcAPI, packedTrx, trxHexEncoded,
+		app.HTTP.retryPolicy(), rebuild)
+	if sendError != nil {
+		// contract key might have been rotated, so refetch it for the next event
+		app.RSAPubKeys.Invalidate(game)
+		logger.Error().Err(sendError).Str(FieldTrxID, trxHexEncoded).Msg("Failed to send signidice_part_2 trx")
+		eventSpan.SetError(sendError)
+		failure = "push_" + string(ClassifyPushError(sendError).Kind)
 		return nil
 	}
-	log.Info().Msgf("Successfully sent signidice_part_2 txn, "+
-		"sessionID: %d, trxID: %s", event.RequestID, trxHexEncoded)
+	logger.Info().Str(FieldTrxID, trxHexEncoded).Msg("Successfully sent signidice_part_2 txn")
+	app.saveSignidiceRecord(&logger, game, event.RequestID, data.Digest, signature, trxHexEncoded)
 	return &trxHexEncoded
 }
 
-func (app *App) RunEventProcessor(ctx context.Context) {
+// RunEventProcessor dispatches events until ctx is done,
+// returns errEventsClosed if broker client stopped delivering events
+func (app *App) RunEventProcessor(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		case eventMessage, ok := <-app.EventMessages:
 			if !ok {
+				app.setBrokerSubscribed(false)
 				log.Debug().Msg("Shutting down cause event-monitor isn't responding")
-				return
+				return errEventsClosed
 			}
 			if len(eventMessage.Events) == 0 {
 				log.Debug().Msg("Gotta event message with no events")
 				break
 			}
-			log.Debug().Msgf("Processing %+v events", len(eventMessage.Events))
+			log.Debug().Int("events", len(eventMessage.Events)).Uint64("offset", eventMessage.Offset).
+				Msg("Processing events")
 			for _, event := range eventMessage.Events {
-				go app.processEvent(event)
+				eventCtx, span := tracing.Start(app.work, "broker_receipt")
+				span.SetAttribute("offset", event.Offset)
+				span.SetAttribute(FieldSessionID, event.RequestID)
+				span.SetAttribute(FieldSender, event.Sender)
+				app.inFlight.add()
+				go func(event *broker.Event) {
+					defer app.inFlight.done()
+					app.processEvent(eventCtx, event)
+				}(event)
+				span.End()
 			}
-			offset := eventMessage.Offset + 1
-			if err := utils.WriteOffset(app.OffsetHandler, offset); err != nil {
-				log.Error().Msgf("Failed to write offset, reason: %s", err.Error())
+			// events polled from chain aren't tracked by broker offset
+			if eventMessage.Offset != ChainEventOffset {
+				app.commitOffset(eventMessage.Offset + 1)
 			}
 		}
 	}
 }
 
+// Run serves HTTP requests and broker events until Stop is called, SIGINT or SIGTERM is received
+// or some component fails. On exit intake of new work stops first, then in-flight work is drained
+// within shutdown timeout and background services are stopped.
 func (app *App) Run(addr string) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	errGroup, ctx := errgroup.WithContext(ctx)
-	defer cancel()
-
-	// no errGroup because ctx close cannot be handled
-	go func() {
-		defer cancel()
-		log.Debug().Msg("starting http server")
-		log.Panic().Msg(graceful.ListenAndServe(addr, app.GetRouter()).Error())
-	}()
+	// background services are used by in-flight work, so they're stopped last
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	background, backgroundCtx := errgroup.WithContext(backgroundCtx)
 
-	errGroup.Go(func() error {
-		defer cancel()
-		log.Debug().Msg("starting event listener")
-		go app.BrokerClient.Run(ctx)
-		if _, err := app.BrokerClient.Subscribe(app.Broker.TopicID, app.Broker.TopicOffset); err != nil {
+	if err := app.ChainTracker.Refresh(); err != nil {
+		log.Warn().Err(err).Msg("Failed to fetch initial chain state")
+	}
+	background.Go(func() error {
+		log.Debug().Dur("interval", app.ChainState.PollInterval).Msg("starting chain state tracker")
+		app.ChainTracker.Run(backgroundCtx)
+		return nil
+	})
+
+	if pool, ok := app.bcAPI.(*NodePool); ok {
+		background.Go(func() error {
+			log.Debug().Dur("interval", pool.HealthCheckInterval).Msg("starting blockchain nodes health checker")
+			pool.RunHealthChecker(backgroundCtx)
+			return nil
+		})
+	}
+
+	if app.Tracer != nil {
+		background.Go(func() error {
+			<-backgroundCtx.Done()
+			// exports spans of drained events which are still batched
+			flushCtx, cancelFlush := context.WithTimeout(context.Background(), app.Shutdown.Timeout)
+			defer cancelFlush()
+			if err := app.Tracer.Shutdown(flushCtx); err != nil {
+				log.Warn().Err(err).Msg("Failed to export traces")
+			}
+			return nil
+		})
+	}
+
+	if app.SnapshotStorage != nil {
+		background.Go(func() error {
+			log.Debug().Dur("interval", app.Snapshots.Interval).Msg("starting bonus snapshot scheduler")
+			app.RunSnapshotScheduler(backgroundCtx)
+			return nil
+		})
+	}
+
+	intake, intakeCtx := errgroup.WithContext(backgroundCtx)
+	server := &http.Server{Addr: addr, Handler: app.GetRouter()}
+	intake.Go(func() error {
+		log.Debug().Str("addr", addr).Msg("starting http server")
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
 			return err
 		}
-		log.Debug().Msgf("starting event processor with offset %v", app.Broker.TopicOffset)
-		app.RunEventProcessor(ctx)
 		return nil
 	})
 
-	errGroup.Go(func() error {
+	listenerCtx, stopListener := context.WithCancel(context.Background())
+	defer stopListener()
+	intake.Go(func() error {
+		log.Debug().Msg("starting event listener")
+		go app.BrokerClient.Run(listenerCtx)
+		return app.runEvents(intakeCtx)
+	})
+
+	intake.Go(func() error {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(quit)
 		select {
-		case <-ctx.Done():
+		case <-intakeCtx.Done():
 			return nil
-		case <-quit:
-			cancel()
+		case sig := <-quit:
+			log.Info().Str("signal", sig.String()).Msg("Received signal, shutting down")
+		case <-app.stop:
+			log.Info().Msg("Stop requested, shutting down")
 		}
-		return nil
+		return errStopRequested
 	})
 
-	return errGroup.Wait()
+	<-intakeCtx.Done()
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), app.Shutdown.Timeout)
+	defer cancelDrain()
+	// stops listening and waits for in-flight requests
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Warn().Err(err).Msg("Failed to finish in-flight HTTP requests")
+	}
+	err := intake.Wait()
+	stopListener()
+
+	if drainErr := app.inFlight.wait(drainCtx); drainErr != nil {
+		log.Warn().Err(drainErr).Msg("Failed to finish in-flight events")
+	}
+	app.cancelWork()
+	stopBackground()
+	if bgErr := background.Wait(); bgErr != nil {
+		log.Warn().Err(bgErr).Msg("Background service failed")
+	}
+	if err == errStopRequested {
+		log.Info().Msg("Shut down gracefully")
+		return nil
+	}
+	return err
 }
 
 func respondWithError(writer ResponseWriter, code int, message string) {
@@ -273,56 +430,93 @@ func (app *App) WhoQuery(writer ResponseWriter, req *Request) {
 }
 
 func (app *App) SignQuery(writer ResponseWriter, req *Request) {
-	log.Info().Msg("Called /sign_transaction")
+	ctx, querySpan := tracing.Start(req.Context(), "sign_transaction")
+	defer querySpan.End()
+	logger := withTrace(log.With().Str(FieldEndpoint, "/sign_transaction").Logger(), querySpan)
+	logger.Info().Msg("Called endpoint")
 	start := time.Now()
+	failure := "" // reason label of failed query
 	defer func() {
 		elapsed := time.Since(start)
 		metrics.SignTransactionProcessingTimeMs.Observe(elapsed.Seconds() * 1000)
+		metrics.SignTransactionRequests.WithLabelValues(metrics.Outcome(failure), failure).Inc()
+		if failure != "" {
+			querySpan.SetAttribute("failure", failure)
+		}
 	}()
+	_, span := tracing.Start(ctx, "deserialize")
 	rawTransaction, _ := ioutil.ReadAll(req.Body)
 	tx := &eos.SignedTransaction{}
 	err := json.Unmarshal(rawTransaction, tx)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		log.Debug().Msgf("failed to deserialize transaction, reason: %s", err.Error())
+		logger.Debug().Err(err).Msg("failed to deserialize transaction")
 		respondWithError(writer, http.StatusBadRequest, "failed to deserialize transaction")
+		failure = "deserialize"
 		return
 	}
-	if err := ValidateDepositTransaction(tx, app.BlockChain.CasinoAccountName, app.BlockChain.PlatformAccountName,
+	_, span = tracing.Start(ctx, "validate")
+	err = ValidateDepositTransaction(tx, app.BlockChain.CasinoAccountName, app.BlockChain.PlatformAccountName,
 		app.BlockChain.PlatformPubKey,
-		app.BlockChain.ChainID); err != nil {
-		log.Debug().Msgf("invalid transaction supplied, reason: %s", err.Error())
+		app.BlockChain.ChainID)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		logger.Debug().Err(err).Msg("invalid transaction supplied")
 		respondWithError(writer, http.StatusBadRequest, "invalid transaction supplied")
+		failure = "invalid_trx"
 		return
 	}
-	signedTx, signError := app.bcAPI.Signer.Sign(tx, app.BlockChain.ChainID, app.BlockChain.EosPubKeys.Deposit)
+	logger = logger.With().Str(FieldPlayer, string(trxPlayer(tx))).Logger()
+	querySpan.SetAttribute(FieldPlayer, string(trxPlayer(tx)))
+	_, span = tracing.Start(ctx, "sign")
+	signedTx, signError := app.bcAPI.Sign(tx, app.BlockChain.ChainID, app.BlockChain.EosPubKeys.Deposit)
+	span.SetError(signError)
+	span.End()
 
 	if signError != nil {
-		log.Warn().Msgf("failed to sign transaction, reason: %s", signError.Error())
+		logger.Warn().Err(signError).Msg("failed to sign transaction")
 		respondWithError(writer, http.StatusInternalServerError, "failed to sign transaction")
+		failure = "sign"
 		return
 	}
-	log.Debug().Msg(signedTx.String())
+	logger.Debug().Msg(signedTx.String())
 	packedTrx, _ := signedTx.Pack(eos.CompressionNone)
 	trxID, err := packedTrx.ID()
 	if err != nil {
-		log.Warn().Msgf("failed to calc trx ID, reason: %s", err.Error())
+		logger.Warn().Err(err).Msg("failed to calc trx ID")
 		respondWithError(writer, http.StatusInternalServerError, "failed to calc trx ID")
+		failure = "trx_id"
 		return
 	}
 
-	if sendError := SendPackedTrxWithRetries(app.bcAPI, packedTrx, trxID.String(),
-		app.HTTP.RetryAmount, app.HTTP.Timeout, app.HTTP.RetryDelay); sendError != nil {
-		log.Debug().Msgf("failed to send transaction to the blockchain, reason: %s", sendError.Error())
+	logger = logger.With().Str(FieldTrxID, trxID.String()).Logger()
+	querySpan.SetAttribute(FieldTrxID, trxID.String())
+	if _, sendError := SendPackedTrxWithRetries(ctx, &logger, app.bcAPI, packedTrx, trxID.String(),
+		app.HTTP.retryPolicy(), nil); sendError != nil {
+		logger.Debug().Err(sendError).Msg("failed to send transaction to the blockchain")
 		respondWithError(writer, http.StatusBadRequest, "failed to send transaction to the blockchain, reason: "+
 			sendError.Error())
+		failure = "push_" + string(ClassifyPushError(sendError).Kind)
 		return
 	}
 
+	logger.Info().Msg("Successfully sent deposit txn")
 	respondWithJSON(writer, http.StatusOK, JSONResponse{"txid": trxID.String()})
 }
 
+// trxPlayer returns account authorized the first action of deposit trx
+func trxPlayer(tx *eos.SignedTransaction) eos.AccountName {
+	if len(tx.Actions) == 0 || len(tx.Actions[0].Authorization) == 0 {
+		return ""
+	}
+	return tx.Actions[0].Authorization[0].Actor
+}
+
 func (app *App) GetBonusPlayersStats(writer ResponseWriter, req *Request) {
-	log.Info().Msg("Called /admin/bonus_players/stats")
+	logger := log.With().Str(FieldEndpoint, "/admin/bonus_players/stats").Logger()
+	logger.Info().Msg("Called endpoint")
 
 	lastPlayer := ""
 	keys, ok := req.URL.Query()["last_player"]
@@ -333,15 +527,17 @@ func (app *App) GetBonusPlayersStats(writer ResponseWriter, req *Request) {
 	playerStats, err := app.getBonusPlayersStats(lastPlayer)
 
 	if err != nil {
-		log.Warn().Msgf("failed to get bonus players: %s", err.Error())
-		respondWithError(writer, http.StatusInternalServerError, "failed to get bonus players: %s"+err.Error())
+		logger.Warn().Err(err).Msg("failed to get bonus players")
+		respondWithError(writer, http.StatusInternalServerError, "failed to get bonus players: "+err.Error())
+		return
 	}
 
 	respondWithJSON(writer, http.StatusOK, playerStats)
 }
 
 func (app *App) GetBonusPlayersBalance(writer ResponseWriter, req *Request) {
-	log.Info().Msg("Called /admin/bonus_players/balance")
+	logger := log.With().Str(FieldEndpoint, "/admin/bonus_players/balance").Logger()
+	logger.Info().Msg("Called endpoint")
 
 	last_player := ""
 	keys, ok := req.URL.Query()["last_player"]
@@ -351,23 +547,200 @@ func (app *App) GetBonusPlayersBalance(writer ResponseWriter, req *Request) {
 
 	playerStats, err := app.getBonusPlayersBalance(last_player)
 	if err != nil {
-		log.Warn().Msgf("failed to get bonus players: %s", err.Error())
-		respondWithError(writer, http.StatusInternalServerError, "failed to get bonus players: %s"+err.Error())
+		logger.Warn().Err(err).Msg("failed to get bonus players")
+		respondWithError(writer, http.StatusInternalServerError, "failed to get bonus players: "+err.Error())
+		return
 	}
 
 	respondWithJSON(writer, http.StatusOK, playerStats)
 }
 
+func (app *App) GetSelfCheckReport(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/selfcheck").Logger()
+	logger.Info().Msg("Called endpoint")
+	respondWithJSON(writer, http.StatusOK, JSONResponse{
+		"ok":     app.SelfCheckReport.OK(),
+		"checks": app.SelfCheckReport,
+	})
+}
+
+func (app *App) GetNodesHealth(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/nodes").Logger()
+	logger.Info().Msg("Called endpoint")
+	pool, ok := app.bcAPI.(*NodePool)
+	if !ok {
+		respondWithError(writer, http.StatusNotFound, "blockchain node pool isn't used")
+		return
+	}
+	respondWithJSON(writer, http.StatusOK, pool.Health())
+}
+
+func parseLastIDParam(req *Request) (*uint64, error) {
+	keys, ok := req.URL.Query()["last_id"]
+	if !ok || len(keys) == 0 {
+		return nil, nil
+	}
+	lastID, err := strconv.ParseUint(keys[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	// the next page starts at lastID+1, which would wrap to the first one
+	if lastID == math.MaxUint64 {
+		return nil, fmt.Errorf("no rows after %d", lastID)
+	}
+	return &lastID, nil
+}
+
+func (app *App) GetCasinoBalance(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/casino/balance").Logger()
+	logger.Info().Msg("Called endpoint")
+
+	symbol := ""
+	keys, ok := req.URL.Query()["symbol"]
+	if ok && len(keys) > 0 {
+		symbol = keys[0]
+	}
+
+	balance, err := app.getCasinoBalance(symbol)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to get casino balance")
+		respondWithError(writer, http.StatusInternalServerError, "failed to get casino balance: "+err.Error())
+		return
+	}
+
+	respondWithJSON(writer, http.StatusOK, balance)
+}
+
+func (app *App) GetCasinoGames(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/casino/games").Logger()
+	logger.Info().Msg("Called endpoint")
+
+	lastID, err := parseLastIDParam(req)
+	if err != nil {
+		respondWithError(writer, http.StatusBadRequest, "invalid 'last_id' parameter")
+		return
+	}
+
+	games, err := app.getCasinoGames(lastID)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to get casino games")
+		respondWithError(writer, http.StatusInternalServerError, "failed to get casino games: "+err.Error())
+		return
+	}
+
+	respondWithJSON(writer, http.StatusOK, games)
+}
+
+func (app *App) GetCasinoGameParams(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/casino/game_params").Logger()
+	logger.Info().Msg("Called endpoint")
+
+	lastID, err := parseLastIDParam(req)
+	if err != nil {
+		respondWithError(writer, http.StatusBadRequest, "invalid 'last_id' parameter")
+		return
+	}
+
+	params, err := app.getCasinoGameParams(lastID)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to get casino game params")
+		respondWithError(writer, http.StatusInternalServerError, "failed to get casino game params: "+err.Error())
+		return
+	}
+
+	respondWithJSON(writer, http.StatusOK, params)
+}
+
+func (app *App) GetSignerResources(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/signer/resources").Logger()
+	logger.Info().Msg("Called endpoint")
+
+	resources, err := app.getSignerResources()
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to get signer resources")
+		respondWithError(writer, http.StatusInternalServerError, "failed to get signer resources: "+err.Error())
+		return
+	}
+
+	respondWithJSON(writer, http.StatusOK, resources)
+}
+
+func parseTimestampParam(req *Request, name string, defaultValue time.Time) (time.Time, error) {
+	keys, ok := req.URL.Query()[name]
+	if !ok || len(keys) == 0 {
+		return defaultValue, nil
+	}
+	seconds, err := strconv.ParseInt(keys[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+func (app *App) GetBonusPlayersDeltas(writer ResponseWriter, req *Request) {
+	logger := log.With().Str(FieldEndpoint, "/admin/bonus_players/deltas").Logger()
+	logger.Info().Msg("Called endpoint")
+
+	if app.SnapshotStorage == nil {
+		respondWithError(writer, http.StatusNotFound, "bonus snapshots are disabled")
+		return
+	}
+
+	from, err := parseTimestampParam(req, "from", time.Unix(0, 0))
+	if err != nil {
+		respondWithError(writer, http.StatusBadRequest, "invalid 'from' timestamp")
+		return
+	}
+	to, err := parseTimestampParam(req, "to", time.Now())
+	if err != nil {
+		respondWithError(writer, http.StatusBadRequest, "invalid 'to' timestamp")
+		return
+	}
+	if to.Before(from) {
+		respondWithError(writer, http.StatusBadRequest, "'to' timestamp is before 'from'")
+		return
+	}
+
+	fromSnapshot, err := app.SnapshotStorage.Closest(from)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to load bonus snapshot")
+		respondWithError(writer, http.StatusInternalServerError, "failed to load bonus snapshot: "+err.Error())
+		return
+	}
+	toSnapshot, err := app.SnapshotStorage.Closest(to)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to load bonus snapshot")
+		respondWithError(writer, http.StatusInternalServerError, "failed to load bonus snapshot: "+err.Error())
+		return
+	}
+
+	respondWithJSON(writer, http.StatusOK, CalcBonusDeltas(fromSnapshot, toSnapshot))
+}
+
 func (app *App) GetRouter() *mux.Router {
 	var router mux.Router
 	router.HandleFunc("/ping", app.PingQuery).Methods("GET")
 	router.HandleFunc("/who", app.WhoQuery).Methods("GET")
+	router.HandleFunc("/health/live", app.LiveQuery).Methods("GET")
+	router.HandleFunc("/health/ready", app.ReadyQuery).Methods("GET")
 	router.HandleFunc("/sign_transaction", app.SignQuery).Methods("POST")
 	router.Handle("/metrics", metrics.GetHandler())
+	router.HandleFunc("/fairness/pubkey", app.GetFairnessPubKeys).Methods("GET")
+	router.HandleFunc("/fairness/{contract:[a-z1-5.]{1,12}}/{req_id:[0-9]+}", app.GetSignidiceRecord).Methods("GET")
 
 	adminRouter := router.PathPrefix("/admin").Subrouter()
 	adminRouter.HandleFunc("/bonus_players/stats", app.GetBonusPlayersStats).Methods("GET")
 	adminRouter.HandleFunc("/bonus_players/balance", app.GetBonusPlayersBalance).Methods("GET")
+	adminRouter.HandleFunc("/bonus_players/deltas", app.GetBonusPlayersDeltas).Methods("GET")
+	adminRouter.HandleFunc("/casino/balance", app.GetCasinoBalance).Methods("GET")
+	adminRouter.HandleFunc("/casino/games", app.GetCasinoGames).Methods("GET")
+	adminRouter.HandleFunc("/casino/game_params", app.GetCasinoGameParams).Methods("GET")
+	adminRouter.HandleFunc("/signer/resources", app.GetSignerResources).Methods("GET")
+	adminRouter.HandleFunc("/nodes", app.GetNodesHealth).Methods("GET")
+	adminRouter.HandleFunc("/selfcheck", app.GetSelfCheckReport).Methods("GET")
+
+	router.Use(metrics.Middleware)
+	router.Use(tracing.Middleware)
 
 	return &router
 }
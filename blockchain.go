@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/DaoCasino/casino-backend/tracing"
 	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/DaoCasino/casino-backend/utils/retry"
 
 	"github.com/eoscanada/eos-go"
 	"github.com/eoscanada/eos-go/ecc"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,7 +28,9 @@ var (
 	}
 )
 
-func NewSigndice(contract, signerAccount eos.AccountName, requestID uint64, signature string) *eos.Action {
+// NewSigndice makes sgdicesecond action with signature encoded as game contract expects
+func NewSigndice(contract, signerAccount eos.AccountName, requestID uint64,
+	signature []byte, scheme utils.RsaScheme) *eos.Action {
 	return &eos.Action{
 		Account: contract,
 		Name:    eos.ActN("sgdicesecond"),
@@ -32,7 +39,7 @@ func NewSigndice(contract, signerAccount eos.AccountName, requestID uint64, sign
 		},
 		ActionData: eos.NewActionData(Signidice{
 			requestID,
-			signature,
+			scheme.Encode(signature),
 		}),
 	}
 }
@@ -44,15 +51,15 @@ type Signidice struct {
 }
 
 func GetSigndiceTransaction(
-	api *eos.API,
+	api BlockchainAPI,
 	contract, signerAccount eos.AccountName,
-	requestID uint64, signature string,
+	requestID uint64, signature []byte, scheme utils.RsaScheme,
 	signidiceKey ecc.PublicKey,
 	txOpts *eos.TxOptions,
 ) (*eos.PackedTransaction, error) {
-	action := NewSigndice(contract, signerAccount, requestID, signature)
+	action := NewSigndice(contract, signerAccount, requestID, signature, scheme)
 	tx := eos.NewSignedTransaction(eos.NewTransaction([]*eos.Action{action}, txOpts))
-	signedTx, err := api.Signer.Sign(tx, txOpts.ChainID, signidiceKey)
+	signedTx, err := api.Sign(tx, txOpts.ChainID, signidiceKey)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +85,7 @@ func ValidateDepositTransaction(
 		return err
 	}
 
-	log.Debug().Msgf("%+v", invariant)
+	log.Debug().Strs("invariant", invariant).Msg("Deposit txn invariant")
 
 	if !isInvariantAllowed(invariant) {
 		return fmt.Errorf("incorrect tx actions")
@@ -97,7 +104,7 @@ func ValidateDepositTransaction(
 	}
 
 	pubKeys, err := tx.SignedByKeys(chainID)
-	log.Debug().Msgf("Deposit txn pubkeys: %v", pubKeys)
+	log.Debug().Interface("pubkeys", pubKeys).Msg("Deposit txn pubkeys")
 	if err != nil {
 		return fmt.Errorf("failed to retrieve public keys from deposit transaction")
 	}
@@ -220,20 +227,66 @@ func isInvariantAllowed(invariant []string) bool {
 	return false
 }
 
-func SendPackedTrxWithRetries(bcAPI *eos.API, packedTrx *eos.PackedTransaction, trxID string,
-	retries int, timeout, retryDelay time.Duration) error {
-	return utils.RetryWithTimeout(func() error {
-		var e error
-		_, e = bcAPI.PushTransaction(packedTrx)
-		if e != nil {
-			if apiErr, ok := e.(eos.APIError); ok {
-				// if error is duplicate trx assume as OK
-				if apiErr.Code == EosInternalErrorCode && apiErr.ErrorStruct.Code == EosInternalDuplicateErrorCode {
-					log.Debug().Msgf("Got duplicate trx error, assuming as OK, trx_id: %s", trxID)
-					return nil
-				}
+// TrxBuilder builds a fresh signed trx, used to rebuild trx with new TAPOS after expiration
+type TrxBuilder func() (*eos.PackedTransaction, string, error)
+
+// SendPackedTrxWithRetries pushes trx retrying only transient failures, fatal errors are returned at once.
+// Expired trx is rebuilt with rebuild if it's set, otherwise expiration is considered fatal.
+// Returns ID of the trx that was finally pushed.
+func SendPackedTrxWithRetries(ctx context.Context, logger *zerolog.Logger, bcAPI BlockchainAPI,
+	packedTrx *eos.PackedTransaction, trxID string, policy retry.Policy, rebuild TrxBuilder) (string, error) {
+	observer := policy.Observer
+	policy.Observer = func(attempt int, err error, delay time.Duration) {
+		if delay > 0 {
+			metrics.PushTransactionRetries.Inc()
+			logger.Debug().Err(err).Int(FieldAttempt, attempt).Dur("delay", delay).Msg("Retrying")
+		}
+		if observer != nil {
+			observer(attempt, err, delay)
+		}
+	}
+	attempt := 0
+	err := retry.Do(ctx, policy, func(attemptCtx context.Context) error {
+		attempt++
+		attemptLogger := logger.With().Int(FieldAttempt, attempt).Str(FieldTrxID, trxID).Logger()
+		_, span := tracing.Start(ctx, "push_transaction")
+		span.SetAttribute(FieldAttempt, attempt)
+		span.SetAttribute(FieldTrxID, trxID)
+		defer span.End()
+
+		_, e := bcAPI.PushTransactionContext(attemptCtx, packedTrx)
+		pushErr := ClassifyPushError(e)
+		if pushErr == nil {
+			return nil
+		}
+		metrics.PushTransactionErrors.WithLabelValues(string(pushErr.Kind), pushErr.Reason()).Inc()
+		span.SetAttribute("error_kind", string(pushErr.Kind))
+		if pushErr.Kind != PushErrorDuplicate {
+			span.SetError(pushErr)
+		}
+		attemptLogger.Debug().Err(pushErr).Str("kind", string(pushErr.Kind)).Msg("Failed to push trx")
+
+		switch pushErr.Kind {
+		case PushErrorDuplicate:
+			// if error is duplicate trx assume as OK
+			metrics.PushTransactionDuplicates.Inc()
+			attemptLogger.Debug().Msg("Got duplicate trx error, assuming as OK")
+			return nil
+		case PushErrorFatal:
+			return retry.Permanent(pushErr)
+		case PushErrorExpired:
+			if rebuild == nil {
+				return retry.Permanent(pushErr)
+			}
+			newTrx, newID, err := rebuild()
+			if err != nil {
+				attemptLogger.Warn().Err(err).Msg("Failed to rebuild expired trx")
+				return pushErr
 			}
+			attemptLogger.Debug().Str("new_trx_id", newID).Msg("Rebuilt expired trx")
+			packedTrx, trxID = newTrx, newID
 		}
-		return e
-	}, retries, timeout, retryDelay)
+		return pushErr
+	})
+	return trxID, err
 }
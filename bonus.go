@@ -5,6 +5,8 @@ import (
 	"strconv"
 )
 
+const tableRowsLimit = 100
+
 type PlayerStats struct {
 	Player          string    `json:"player"`
 	SessionsCreated uint64    `json:"sessions_created"`
@@ -25,7 +27,7 @@ func (app *App) getBonusPlayersStats(lastPlayer string) ([]PlayerStats, error) {
 		Scope:      string(app.BlockChain.CasinoAccountName),
 		Table:      "playerstats",
 		LowerBound: strconv.FormatUint(nextPlayer(lastPlayer), 10),
-		Limit:      100,
+		Limit:      tableRowsLimit,
 		JSON:       true,
 	})
 	if err != nil {
@@ -48,7 +50,7 @@ func (app *App) getBonusPlayersBalance(lastPlayer string) ([]PlayerBalance, erro
 		Scope:      string(app.BlockChain.CasinoAccountName),
 		LowerBound: strconv.FormatUint(nextPlayer(lastPlayer), 10),
 		Table:      "bonusbalance",
-		Limit:      100,
+		Limit:      tableRowsLimit,
 		JSON:       true,
 	})
 	if err != nil {
@@ -65,6 +67,40 @@ func (app *App) getBonusPlayersBalance(lastPlayer string) ([]PlayerBalance, erro
 	return playersBalance, nil
 }
 
+// getAllBonusPlayersStats walks the whole playerstats table page by page
+func (app *App) getAllBonusPlayersStats() ([]PlayerStats, error) {
+	var result []PlayerStats
+	lastPlayer := ""
+	for {
+		page, err := app.getBonusPlayersStats(lastPlayer)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+		if len(page) < tableRowsLimit {
+			return result, nil
+		}
+		lastPlayer = page[len(page)-1].Player
+	}
+}
+
+// getAllBonusPlayersBalance walks the whole bonusbalance table page by page
+func (app *App) getAllBonusPlayersBalance() ([]PlayerBalance, error) {
+	var result []PlayerBalance
+	lastPlayer := ""
+	for {
+		page, err := app.getBonusPlayersBalance(lastPlayer)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+		if len(page) < tableRowsLimit {
+			return result, nil
+		}
+		lastPlayer = page[len(page)-1].Player
+	}
+}
+
 func nextPlayer(player string) uint64 {
 	if player == "" {
 		return 0
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/eoscanada/eos-go"
+)
+
+const (
+	casinoGamesTable      = "game"
+	casinoGameParamsTable = "gameparams"
+	tokenContract         = "eosio.token"
+)
+
+type AccountResources struct {
+	Account  eos.AccountName          `json:"account"`
+	RAMQuota eos.Int64                `json:"ram_quota"`
+	RAMUsage eos.Int64                `json:"ram_usage"`
+	NetLimit eos.AccountResourceLimit `json:"net_limit"`
+	CPULimit eos.AccountResourceLimit `json:"cpu_limit"`
+	Balance  eos.Asset                `json:"core_liquid_balance"`
+}
+
+func (app *App) getCasinoBalance(symbol string) ([]eos.Asset, error) {
+	return app.bcAPI.GetCurrencyBalance(app.BlockChain.CasinoAccountName, symbol, eos.AN(tokenContract))
+}
+
+// getCasinoTableRows returns raw rows of casino's table keyed by uint64 id starting after lastID
+func (app *App) getCasinoTableRows(table string, lastID *uint64) ([]json.RawMessage, error) {
+	lowerBound := ""
+	if lastID != nil {
+		lowerBound = strconv.FormatUint(*lastID+1, 10)
+	}
+	resp, err := app.bcAPI.GetTableRows(eos.GetTableRowsRequest{
+		Code:       string(app.BlockChain.CasinoAccountName),
+		Scope:      string(app.BlockChain.CasinoAccountName),
+		Table:      table,
+		LowerBound: lowerBound,
+		Limit:      tableRowsLimit,
+		JSON:       true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []json.RawMessage{}
+
+	err = resp.JSONToStructs(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (app *App) getCasinoGames(lastID *uint64) ([]json.RawMessage, error) {
+	return app.getCasinoTableRows(casinoGamesTable, lastID)
+}
+
+func (app *App) getCasinoGameParams(lastID *uint64) ([]json.RawMessage, error) {
+	return app.getCasinoTableRows(casinoGameParamsTable, lastID)
+}
+
+func (app *App) getSignerResources() (*AccountResources, error) {
+	account, err := app.bcAPI.GetAccount(app.BlockChain.SignerAccountName)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountResources{
+		Account:  account.AccountName,
+		RAMQuota: account.RAMQuota,
+		RAMUsage: account.RAMUsage,
+		NetLimit: account.NetLimit,
+		CPULimit: account.CPULimit,
+		Balance:  account.CoreLiquidBalance,
+	}, nil
+}
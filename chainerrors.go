@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eoscanada/eos-go"
+)
+
+// see: https://github.com/DaoCasino/DAObet/blob/master/libraries/chain/include/eosio/chain/exceptions.hpp
+const (
+	EosInternalDuplicateErrorCode    = 3040008 // tx_duplicate
+	EosExpiredTrxErrorCode           = 3040005 // expired_tx_exception
+	EosInvalidRefBlockErrorCode      = 3040007 // invalid_ref_block_exception
+	EosRAMUsageExceededErrorCode     = 3080001 // ram_usage_exceeded
+	EosNetUsageExceededErrorCode     = 3080002 // tx_net_usage_exceeded
+	EosCPUUsageExceededErrorCode     = 3080004 // tx_cpu_usage_exceeded
+	EosDeadlineErrorCode             = 3080006 // deadline_exception
+	EosLeewayDeadlineErrorCode       = 3081001 // leeway_deadline_exception
+	EosChainTypeErrorCodeBase        = 3010000 // chain_type_exception and abi exceptions
+	EosTransactionErrorCodeBase      = 3040000 // transaction_exception
+	EosActionValidateErrorCodeBase   = 3050000 // action_validate_exception incl. eosio_assert_message_exception
+	EosAuthorizationErrorCodeBase    = 3090000 // authorization_exception incl. unsatisfied_authorization
+	eosErrorCodeGroupSize            = 10000
+	eosAssertionFailureMessagePrefix = "assertion failure with message: "
+)
+
+type PushErrorKind string
+
+const (
+	// PushErrorRetryable is a transient failure, the same trx can be pushed again
+	PushErrorRetryable PushErrorKind = "retryable"
+	// PushErrorExpired means trx TAPOS or expiration is not valid anymore, trx should be rebuilt
+	PushErrorExpired PushErrorKind = "expired"
+	// PushErrorDuplicate means trx is already accepted by the chain
+	PushErrorDuplicate PushErrorKind = "duplicate"
+	// PushErrorFatal means trx will never be accepted, e.g. contract assertion or missing authorization
+	PushErrorFatal PushErrorKind = "fatal"
+)
+
+// PushError is a classified error of transaction push
+type PushError struct {
+	Kind    PushErrorKind
+	Code    int    // chain exception code, 0 if error isn't chain one
+	Name    string // chain exception name, e.g. eosio_assert_message_exception
+	Message string // assertion message or error details
+	Err     error
+}
+
+func (e *PushError) Error() string {
+	if e.Name == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// Reason returns low cardinality error description suitable for metrics label
+func (e *PushError) Reason() string {
+	if e.Name == "" {
+		return "node_error"
+	}
+	return e.Name
+}
+
+func inCodeGroup(code, base int) bool {
+	return code >= base && code < base+eosErrorCodeGroupSize
+}
+
+func classifyChainErrorCode(code int) PushErrorKind {
+	switch {
+	case code == EosInternalDuplicateErrorCode:
+		return PushErrorDuplicate
+	case code == EosExpiredTrxErrorCode, code == EosInvalidRefBlockErrorCode:
+		return PushErrorExpired
+	case code == EosNetUsageExceededErrorCode, code == EosCPUUsageExceededErrorCode,
+		code == EosDeadlineErrorCode, code == EosLeewayDeadlineErrorCode:
+		return PushErrorRetryable
+	case code == EosRAMUsageExceededErrorCode,
+		inCodeGroup(code, EosChainTypeErrorCodeBase),
+		inCodeGroup(code, EosTransactionErrorCodeBase),
+		inCodeGroup(code, EosActionValidateErrorCodeBase),
+		inCodeGroup(code, EosAuthorizationErrorCodeBase):
+		return PushErrorFatal
+	}
+	return PushErrorRetryable
+}
+
+// chainErrorMessage returns the most specific message from error details, i.e. contract assertion text
+func chainErrorMessage(apiErr eos.APIError) string {
+	for _, detail := range apiErr.ErrorStruct.Details {
+		if strings.HasPrefix(detail.Message, eosAssertionFailureMessagePrefix) {
+			return strings.TrimPrefix(detail.Message, eosAssertionFailureMessagePrefix)
+		}
+	}
+	if len(apiErr.ErrorStruct.Details) > 0 {
+		return apiErr.ErrorStruct.Details[0].Message
+	}
+	return apiErr.ErrorStruct.What
+}
+
+// ClassifyPushError converts error returned by PushTransaction into PushError
+func ClassifyPushError(err error) *PushError {
+	if err == nil {
+		return nil
+	}
+	if pushErr, ok := err.(*PushError); ok {
+		return pushErr
+	}
+	apiErr, ok := err.(eos.APIError)
+	if !ok || apiErr.Code != EosInternalErrorCode || apiErr.ErrorStruct.Code == 0 {
+		return &PushError{Kind: PushErrorRetryable, Err: err}
+	}
+	return &PushError{
+		Kind:    classifyChainErrorCode(apiErr.ErrorStruct.Code),
+		Code:    apiErr.ErrorStruct.Code,
+		Name:    apiErr.ErrorStruct.Name,
+		Message: chainErrorMessage(apiErr),
+		Err:     err,
+	}
+}
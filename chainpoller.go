@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/DaoCasino/casino-backend/offsetstore"
+	broker "github.com/DaoCasino/platform-action-monitor-client"
+	"github.com/eoscanada/eos-go"
+	"github.com/rs/zerolog/log"
+)
+
+// ChainEventOffset marks messages of events polled from chain, they don't move broker offset
+const ChainEventOffset = math.MaxUint64
+
+type FallbackConfig struct {
+	After        time.Duration // broker unavailable longer switches to chain polling
+	PollInterval time.Duration
+	Rewind       uint32 // blocks before irreversible one cursor is kept at while broker delivers events
+	MaxBlocks    uint32 // blocks read per poll
+	Action       eos.ActionName
+	SessionTable string
+	DigestField  string
+}
+
+// ChainPoller is an event source reading irreversible blocks from the node. It looks for signidice part 1
+// actions pushed to game contracts and reads digest to sign from the session they've updated.
+// Blocks contain top-level actions only, so broker notifications themselves can't be read.
+type ChainPoller struct {
+	bcAPI  BlockchainAPI
+	cursor offsetstore.OffsetStore // last read block
+	events chan<- *broker.EventMessage
+	games  map[eos.AccountName]bool
+	cfg    *FallbackConfig
+
+	m      sync.Mutex
+	topic  broker.EventType
+	active bool
+	follow bool
+}
+
+func NewChainPoller(bcAPI BlockchainAPI, cursor offsetstore.OffsetStore, events chan<- *broker.EventMessage,
+	games []eos.AccountName, cfg *FallbackConfig) *ChainPoller {
+	p := &ChainPoller{bcAPI: bcAPI, cursor: cursor, events: events, games: make(map[eos.AccountName]bool),
+		cfg: cfg}
+	for _, game := range games {
+		p.games[game] = true
+	}
+	return p
+}
+
+// ListenAndServe does nothing as node is called on every poll
+func (p *ChainPoller) ListenAndServe(ctx context.Context) error {
+	return nil
+}
+
+// Subscribe starts polling, offset is ignored as blocks are read from the saved cursor
+func (p *ChainPoller) Subscribe(eventType broker.EventType, offset uint64) (bool, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.topic, p.active = eventType, true
+	return true, nil
+}
+
+func (p *ChainPoller) Unsubscribe(eventType broker.EventType) (bool, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.active = false
+	return true, nil
+}
+
+// Follow moves cursor close to irreversible block on the next poll, it's called while broker delivers events.
+// Cursor is kept Rewind blocks behind, so that events broker lagged on are read again after switching.
+func (p *ChainPoller) Follow() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.follow = true
+}
+
+// Run polls node until ctx is done
+func (p *ChainPoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.poll(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to poll chain for events")
+			}
+		}
+	}
+}
+
+func (p *ChainPoller) poll(ctx context.Context) error {
+	p.m.Lock()
+	topic, active, follow := p.topic, p.active, p.follow
+	p.follow = false
+	p.m.Unlock()
+	if !active && !follow {
+		return nil
+	}
+
+	info, err := p.bcAPI.GetInfo()
+	if err != nil {
+		return err
+	}
+	lib := info.LastIrreversibleBlockNum
+	cursor, err := p.cursor.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load block cursor: %s", err.Error())
+	}
+	last := uint32(cursor)
+	if rewound := lib - p.cfg.Rewind; lib > p.cfg.Rewind && (last == 0 || follow && rewound > last) {
+		last = rewound
+	}
+	if active {
+		last, err = p.readBlocks(ctx, topic, last, lib)
+	}
+	if uint64(last) != cursor {
+		if saveErr := p.cursor.Save(uint64(last)); saveErr != nil {
+			return fmt.Errorf("failed to save block cursor: %s", saveErr.Error())
+		}
+		metrics.ChainPollBlockNum.Set(float64(last))
+	}
+	return err
+}
+
+// readBlocks sends events of blocks after last up to lib but not more than MaxBlocks,
+// returns the last block read
+func (p *ChainPoller) readBlocks(ctx context.Context, topic broker.EventType, last, lib uint32) (uint32, error) {
+	if p.cfg.MaxBlocks > 0 && lib > last && lib-last > p.cfg.MaxBlocks {
+		lib = last + p.cfg.MaxBlocks
+	}
+	for last < lib {
+		num := last + 1
+		events, err := p.readBlock(num, topic)
+		if err != nil {
+			return last, fmt.Errorf("failed to read block %d: %s", num, err.Error())
+		}
+		if len(events) > 0 {
+			log.Info().Uint32("block", num).Int("events", len(events)).Msg("Polled events from chain")
+			select {
+			case p.events <- &broker.EventMessage{Offset: ChainEventOffset, Events: events}:
+			case <-ctx.Done():
+				return last, nil
+			}
+		}
+		last = num
+	}
+	return last, nil
+}
+
+func (p *ChainPoller) readBlock(num uint32, topic broker.EventType) ([]*broker.Event, error) {
+	block, err := p.bcAPI.GetBlockByNum(num)
+	if err != nil {
+		return nil, err
+	}
+	var events []*broker.Event
+	for _, receipt := range block.Transactions {
+		// deferred transactions are referenced by ID only
+		if receipt.Status != eos.TransactionStatusExecuted || receipt.Transaction.Packed == nil {
+			continue
+		}
+		trx, err := receipt.Transaction.Packed.Unpack()
+		if err != nil {
+			return nil, err
+		}
+		for _, action := range trx.Actions {
+			if !p.games[action.Account] || action.Name != p.cfg.Action {
+				continue
+			}
+			var params Signidice
+			if err := eos.UnmarshalBinary(action.HexData, &params); err != nil {
+				return nil, fmt.Errorf("failed to decode %s action of %s: %s", action.Name, action.Account,
+					err.Error())
+			}
+			digest, err := p.sessionDigest(action.Account, params.RequestID)
+			if err != nil {
+				// session could have been finished since then
+				log.Warn().Err(err).Uint64(FieldSessionID, params.RequestID).Str(FieldSender, string(action.Account)).
+					Msg("Skipping polled event")
+				continue
+			}
+			data, err := json.Marshal(map[string]json.RawMessage{"digest": digest})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, &broker.Event{
+				Offset:    uint64(num),
+				Sender:    string(action.Account),
+				RequestID: params.RequestID,
+				EventType: topic,
+				Data:      data,
+			})
+		}
+	}
+	return events, nil
+}
+
+// sessionDigest reads digest game contract expects to be signed from its session table
+func (p *ChainPoller) sessionDigest(game eos.AccountName, sessionID uint64) (json.RawMessage, error) {
+	key := strconv.FormatUint(sessionID, 10)
+	resp, err := p.bcAPI.GetTableRows(eos.GetTableRowsRequest{
+		Code:       string(game),
+		Scope:      string(game),
+		Table:      p.cfg.SessionTable,
+		LowerBound: key,
+		UpperBound: key,
+		Limit:      1,
+		JSON:       true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]json.RawMessage
+	if err := resp.JSONToStructs(&rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("session isn't found in table %s of %s", p.cfg.SessionTable, game)
+	}
+	digest, ok := rows[0][p.cfg.DigestField]
+	if !ok {
+		return nil, fmt.Errorf("field %s isn't found in table %s of %s", p.cfg.DigestField, p.cfg.SessionTable, game)
+	}
+	return digest, nil
+}
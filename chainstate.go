@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/eoscanada/eos-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RefBlockLIB uses last irreversible block as TAPOS reference
+	RefBlockLIB = "lib"
+	// RefBlockHead uses head block minus HeadBlockOffset as TAPOS reference
+	RefBlockHead = "head"
+	// bounds refresh if poll interval isn't set, e.g. for a one-off refresh
+	defaultRefreshTimeout = 5 * time.Second
+)
+
+type ChainStateConfig struct {
+	PollInterval    time.Duration
+	MaxStaleness    time.Duration // chain state older than that isn't used for trx building
+	RefBlock        string        // RefBlockLIB or RefBlockHead
+	HeadBlockOffset uint32
+}
+
+// ChainState is an immutable snapshot of chain head info
+type ChainState struct {
+	Info       *eos.InfoResp
+	RefBlockID eos.Checksum256
+	UpdatedAt  time.Time
+}
+
+// ChainTracker polls chain head in background and serves TAPOS references without blocking
+type ChainTracker struct {
+	api   BlockchainAPI
+	state atomic.Value // *ChainState
+	*ChainStateConfig
+}
+
+func NewChainTracker(api BlockchainAPI, cfg *ChainStateConfig) *ChainTracker {
+	return &ChainTracker{api: api, ChainStateConfig: cfg}
+}
+
+func (t *ChainTracker) State() *ChainState {
+	state, _ := t.state.Load().(*ChainState)
+	return state
+}
+
+// Staleness returns time passed since the last successful refresh
+func (t *ChainTracker) Staleness() time.Duration {
+	state := t.State()
+	if state == nil {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Since(state.UpdatedAt)
+}
+
+// TxOpts returns trx options based on the latest chain state, fails if state is stale
+func (t *ChainTracker) TxOpts() (*eos.TxOptions, error) {
+	state := t.State()
+	if state == nil {
+		return nil, fmt.Errorf("chain state isn't fetched yet")
+	}
+	if staleness := time.Since(state.UpdatedAt); t.MaxStaleness > 0 && staleness > t.MaxStaleness {
+		return nil, fmt.Errorf("chain state is stale, last update was %v ago", staleness)
+	}
+	return &eos.TxOptions{
+		ChainID:     state.Info.ChainID,
+		HeadBlockID: state.RefBlockID,
+	}, nil
+}
+
+func (t *ChainTracker) refBlockID(info *eos.InfoResp) (eos.Checksum256, error) {
+	switch t.RefBlock {
+	case RefBlockHead:
+		if t.HeadBlockOffset == 0 || t.HeadBlockOffset >= info.HeadBlockNum {
+			return info.HeadBlockID, nil
+		}
+		block, err := t.api.GetBlockByNum(info.HeadBlockNum - t.HeadBlockOffset)
+		if err != nil {
+			return nil, err
+		}
+		return block.ID, nil
+	default:
+		return info.LastIrreversibleBlockID, nil
+	}
+}
+
+// Refresh fetches chain info and atomically replaces the current state.
+// Fetching is bounded by PollInterval, so a hanging node can't freeze the tracker.
+func (t *ChainTracker) Refresh() error {
+	timeout := t.PollInterval
+	if timeout <= 0 {
+		timeout = defaultRefreshTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	info, err := t.api.GetInfoContext(ctx)
+	if err != nil {
+		return err
+	}
+	refBlockID, err := t.refBlockID(info)
+	if err != nil {
+		return err
+	}
+	t.state.Store(&ChainState{Info: info, RefBlockID: refBlockID, UpdatedAt: time.Now()})
+	metrics.ChainHeadBlockNum.Set(float64(info.HeadBlockNum))
+	metrics.ChainLIBNum.Set(float64(info.LastIrreversibleBlockNum))
+	return nil
+}
+
+func (t *ChainTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Refresh(); err != nil {
+				log.Warn().Err(err).Msg("Failed to refresh chain state")
+			}
+			metrics.ChainStateAgeSeconds.Set(t.Staleness().Seconds())
+		}
+	}
+}
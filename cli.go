@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/offsetstore"
+	broker "github.com/DaoCasino/platform-action-monitor-client"
+	"github.com/rs/zerolog/log"
+)
+
+// Command is run instead of the service if its name is given after flags, e.g. `casino -config c.toml offset show`
+type Command struct {
+	Usage string
+	Run   func(cfg *Config, args []string, out io.Writer) error
+	// RunConfig is used instead of Run by commands reading config file themselves
+	RunConfig func(configPath string, args []string, out io.Writer) error
+}
+
+var commands = map[string]*Command{
+	"offset": {
+		Usage: "offset show | offset set [-force] <offset>\n" +
+			"\tshow or change broker offset the service resumes from, stop the service before changing it",
+		Run: runOffsetCommand,
+	},
+	"replay": {
+		Usage: "replay -from <offset> -to <offset> [-dry-run] [-idle <duration>]\n" +
+			"\tprocess broker events of the range without moving stored offset, dry run only prints them",
+		Run: runReplayCommand,
+	},
+	"events": {
+		Usage: "events tail [-from <offset>]\n" +
+			"\tprint decoded broker events starting from stored offset until interrupted",
+		Run: runEventsCommand,
+	},
+	"keys": {
+		Usage: "keys rsa-generate [-bits <n>] | keys rsa-pubkey [-key-file <file> | -key-id <id>] [-game <contract>] " +
+			"[-pem] | keys eos-pubkey [<wif>]\n" +
+			"\tgenerate RSA key, print public key of RSA key for contract or public keys of EOS keys",
+		Run: runKeysCommand,
+	},
+	"sign-digest": {
+		Usage: "sign-digest [-key-file <file> | -key-id <id>] [-game <contract>] <hex digest>\n" +
+			"\tsign digest with RSA key and scheme of game the way signidice is signed",
+		Run: runSignDigest,
+	},
+	"verify-digest": {
+		Usage: "verify-digest [-key-file <file> | -key-id <id> | -pubkey <key>] [-game <contract>] " +
+			"<hex digest> <signature>\n" +
+			"\tverify signature of digest made with RSA key and scheme of game",
+		Run: runVerifyDigest,
+	},
+	"config": {
+		Usage: "config check\n" +
+			"\tprint effective config with secrets redacted and report its problems",
+		RunConfig: runConfigCommand,
+	},
+}
+
+// RunCommand runs command named by the first arg with config read from configPath
+func RunCommand(configPath string, args []string, out io.Writer) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, available commands: %s", args[0], strings.Join(commandNames(), ", "))
+	}
+	if command.RunConfig != nil {
+		return command.RunConfig(configPath, args[1:], out)
+	}
+	cfg, err := GetConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %s", err.Error())
+	}
+	return command.Run(cfg, args[1:], out)
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printUsage prints flags of the service and usage of commands
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nCommands:\n")
+	for _, name := range commandNames() {
+		fmt.Fprintf(out, "  %s\n", commands[name].Usage)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+func runOffsetCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("offset subcommand isn't set, use show or set")
+	}
+	store, err := offsetstore.Open(cfg.Broker.OffsetBackend, cfg.Broker.TopicOffsetPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	current, loadErr := store.Load()
+
+	switch args[0] {
+	case "show":
+		if loadErr != nil {
+			return fmt.Errorf("failed to load offset: %s", loadErr.Error())
+		}
+		fmt.Fprintln(out, current)
+		return nil
+	case "set":
+		flags := newFlagSet("offset set")
+		force := flags.Bool("force", false, "allow moving offset forward, events in between are skipped")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("offset to set isn't given")
+		}
+		offset, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset %q: %s", flags.Arg(0), err.Error())
+		}
+		// corrupted offset is replaced, any other failure means store can't be trusted
+		if loadErr != nil && !errors.Is(loadErr, offsetstore.ErrCorrupted) {
+			return fmt.Errorf("failed to load offset: %s", loadErr.Error())
+		}
+		if loadErr == nil && offset > current && !*force {
+			return fmt.Errorf("moving offset forward from %d to %d skips events, use -force to do it anyway",
+				current, offset)
+		}
+		if err := store.Save(offset); err != nil {
+			return fmt.Errorf("failed to save offset: %s", err.Error())
+		}
+		if loadErr != nil {
+			fmt.Fprintf(out, "corrupted offset replaced with %d\n", offset)
+		} else {
+			fmt.Fprintf(out, "offset changed from %d to %d\n", current, offset)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown offset subcommand %q, use show or set", args[0])
+}
+
+func runReplayCommand(cfg *Config, args []string, out io.Writer) error {
+	flags := newFlagSet("replay")
+	from := flags.Uint64("from", 0, "first offset to replay")
+	to := flags.Uint64("to", 0, "last offset to replay")
+	dryRun := flags.Bool("dry-run", false, "print events instead of processing them")
+	idle := flags.Duration("idle", 10*time.Second, "stop if broker sends no events for that long")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *to < *from {
+		return fmt.Errorf("invalid range: %d > %d", *from, *to)
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	handle := printEvent(out)
+	if !*dryRun {
+		var err error
+		if handle, err = replayHandler(ctx, cfg, out); err != nil {
+			return err
+		}
+	}
+
+	events := make(chan *broker.EventMessage)
+	client := NewBrokerClient(cfg, events)
+	if err := client.ListenAndServe(ctx); err != nil {
+		return fmt.Errorf("failed to connect to broker: %s", err.Error())
+	}
+	last, err := replayEvents(ctx, client, events, cfg.Broker.TopicID, *from, *to, *idle, handle)
+	if err != nil {
+		return err
+	}
+	if last < *to {
+		log.Warn().Uint64("last", last).Uint64("to", *to).Msg("Replay stopped before the end of the range")
+	}
+	return nil
+}
+
+// replayHandler processes events and prints their trx IDs, stored offset isn't touched
+func replayHandler(ctx context.Context, cfg *Config, out io.Writer) (func(event *broker.Event) error, error) {
+	app, err := MakeReplayApp(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.ChainTracker.Refresh(); err != nil {
+		return nil, fmt.Errorf("failed to fetch chain state: %s", err.Error())
+	}
+	go app.ChainTracker.Run(ctx)
+	return func(event *broker.Event) error {
+		result := "failed"
+		if trxID := app.processEvent(ctx, event); trxID != nil {
+			result = *trxID
+		}
+		_, err := fmt.Fprintf(out, "%d\t%s\t%d\t%s\n", event.Offset, event.Sender, event.RequestID, result)
+		return err
+	}, nil
+}
+
+func runEventsCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "tail" {
+		return fmt.Errorf("unknown events subcommand, use tail")
+	}
+	flags := newFlagSet("events tail")
+	from := flags.String("from", "", "offset to start from, stored offset if empty")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	var offset uint64
+	if *from == "" {
+		store, stored, err := OpenOffsetStore(cfg)
+		if err != nil {
+			return err
+		}
+		offset = stored
+		if err := store.Close(); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if offset, err = strconv.ParseUint(*from, 10, 64); err != nil {
+			return fmt.Errorf("invalid offset %q: %s", *from, err.Error())
+		}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	events := make(chan *broker.EventMessage)
+	client := NewBrokerClient(cfg, events)
+	if err := client.ListenAndServe(ctx); err != nil {
+		return fmt.Errorf("failed to connect to broker: %s", err.Error())
+	}
+	printer := printEvent(out)
+	return streamEvents(ctx, client, events, cfg.Broker.TopicID, offset, 0, func(event *broker.Event) (bool, error) {
+		return true, printer(event)
+	})
+}
+
+// printEvent writes event as a JSON line
+func printEvent(out io.Writer) func(event *broker.Event) error {
+	return func(event *broker.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+}
+
+// replayEvents passes events of offsets from..to to handle, returns offset of the last handled event
+func replayEvents(ctx context.Context, listener EventListener, events <-chan *broker.EventMessage,
+	topic broker.EventType, from, to uint64, idle time.Duration, handle func(event *broker.Event) error) (uint64, error) {
+	var last uint64
+	err := streamEvents(ctx, listener, events, topic, from, idle, func(event *broker.Event) (bool, error) {
+		if event.Offset < from {
+			return true, nil
+		}
+		if event.Offset > to {
+			return false, nil
+		}
+		last = event.Offset
+		return event.Offset < to, handle(event)
+	})
+	return last, err
+}
+
+// streamEvents subscribes to topic from offset and passes events to handle until it returns false,
+// no events come for idle or ctx is done. Zero idle waits for events forever.
+func streamEvents(ctx context.Context, listener EventListener, events <-chan *broker.EventMessage,
+	topic broker.EventType, offset uint64, idle time.Duration, handle func(event *broker.Event) (bool, error)) error {
+	if _, err := listener.Subscribe(topic, offset); err != nil {
+		return fmt.Errorf("failed to subscribe to broker events: %s", err.Error())
+	}
+	defer func() {
+		if _, err := listener.Unsubscribe(topic); err != nil {
+			log.Warn().Err(err).Msg("Failed to unsubscribe from broker events")
+		}
+	}()
+	for {
+		var timeout <-chan time.Time
+		if idle > 0 {
+			timeout = time.After(idle)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout:
+			log.Info().Dur("idle", idle).Msg("No more events")
+			return nil
+		case message, ok := <-events:
+			if !ok {
+				return errEventsClosed
+			}
+			for _, event := range message.Events {
+				next, err := handle(event)
+				if err != nil {
+					return err
+				}
+				if !next {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// interruptContext is cancelled on SIGINT or SIGTERM
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(quit)
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
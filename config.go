@@ -4,33 +4,132 @@ import broker "github.com/DaoCasino/platform-action-monitor-client"
 
 type Config struct {
 	Server struct {
-		Port     int    `default:"80"`
-		LogLevel string `default:"INFO"`
+		Port      int    `default:"80"`
+		LogLevel  string `default:"INFO"`
+		LogFormat string `default:"console"` // console or json
+		// seconds to wait for in-flight events and requests on shutdown
+		ShutdownTimeout int `default:"30"`
 	}
 	Broker struct {
-		TopicOffsetPath      string
-		URL                  string
-		TopicID              broker.EventType
-		ReconnectionAttempts int `default:"3"`
-		ReconnectionDelay    int `default:"3"`
-		Token                string
+		TopicOffsetPath string
+		OffsetBackend   string `default:"file"` // "file" or "bolt"
+		// start from the beginning of the topic if stored offset is corrupted instead of failing
+		IgnoreCorruptedOffset bool
+		URL                   string
+		TopicID               broker.EventType
+		ReconnectionAttempts  int    `default:"3"`
+		ReconnectionDelay     int    `default:"3"`
+		Token                 string `secret:"true"`
 	}
 	BlockChain struct {
-		DepositKey           string
-		SigniDiceKey         string
+		DepositKey           string `secret:"true"`
+		SigniDiceKey         string `secret:"true"`
 		SigniDiceAccountName string
 		CasinoAccountName    string
-		RSAKey               string
+		RSAKey               string `secret:"true"`
 		URL                  string
+		URLs                 []string // used instead of URL if set
+		HealthCheckInterval  int      `default:"5"`  // seconds
+		MaxHeadBlockLag      int      `default:"60"` // blocks, 0 disables check
+		RequestTimeout       int      `default:"2"`  // seconds per node request
 		ChainID              string
 		PlatformAccountName  string
 		PlatformPubKey       string
+		GameContracts        []string
 	}
 	HTTP struct {
-		RetryAmount int `default:"3"`
-		RetryDelay  int `default:"1"`
-		Timeout     int `default:"3"`
+		RetryAmount   int     `default:"3"`
+		RetryDelay    int     `default:"1"`   // seconds, doubled after every failed attempt
+		MaxRetryDelay int     `default:"10"`  // seconds, 0 means unlimited
+		RetryJitter   float64 `default:"0.2"` // fraction of delay randomized
+		Timeout       int     `default:"3"`   // seconds per attempt
+	}
+	ChainState struct {
+		PollInterval    int    `default:"1"`   // seconds
+		MaxStaleness    int    `default:"10"`  // seconds, 0 disables check
+		RefBlock        string `default:"lib"` // "lib" or "head"
+		HeadBlockOffset int    `default:"0"`   // used with "head" ref block
+	}
+	SelfCheck struct {
+		Mode              string `default:"strict"` // "strict", "degraded" or "off"
+		DepositPermission string `default:"deposit"`
+	}
+	RSA struct {
+		PubKeyTable    string           `default:"global"`
+		PubKeyField    string           `default:"rsa_pubkey"`
+		PubKeyCacheTTL int              `default:"10"` // seconds
+		Keys           []RSAKeyEntry    // additional keys used for rotation
+		Schemes        []RSASchemeEntry // signing schemes of games, default is pkcs1v15-sha256-base64
+	}
+	Keys struct {
+		Source              string `default:"config"` // "config", "file", "keystore" or "remote"
+		DepositKeyFile      string
+		SigniDiceKeyFile    string
+		RSAKeyFile          string // PEM or base64 encoded PEM
+		KeystorePath        string
+		KeystorePassphrase  string `secret:"true"` // better set via KEYS_KEYSTOREPASSPHRASE env
+		RemoteSignerURL     string // keosd compatible wallet
+		RemoteWallet        string `default:"default"`
+		RemoteSignerTimeout int    `default:"5"` // seconds per wallet request
+		DepositPubKey       string // used with remote signer
+		SigniDicePubKey     string // used with remote signer
+	}
+	Fairness struct {
+		Path string // signidice records are disabled if path is empty
+	}
+	Tracing struct {
+		Exporter      string            // "otlp" or "file", tracing is disabled if empty
+		Endpoint      string            // OTLP/HTTP collector, e.g. http://localhost:4318
+		Headers       map[string]string `secret:"true"` // sent to collector, e.g. auth token
+		FilePath      string            // used with "file" exporter
+		ServiceName   string            `default:"casino-backend"`
+		BatchSize     int               `default:"512"`
+		FlushInterval int               `default:"5"` // seconds
+	}
+	Election struct {
+		Backend   string // "file" or "memory", every replica processes events if empty
+		LeasePath string // lease file on storage shared by replicas
+		LeaseTTL  int    `default:"10"` // seconds, standby takes over lease not renewed for that long
+		ID        string // replica ID, hostname and pid if empty
 	}
+	Fallback struct {
+		CursorPath   string // last read block, chain polling fallback is disabled if empty
+		After        int    `default:"30"`  // seconds of broker unavailability before polling chain
+		PollInterval int    `default:"1"`   // seconds
+		Rewind       int    `default:"120"` // blocks read again after switching, broker may lag behind chain
+		MaxBlocks    int    `default:"100"` // blocks read per poll
+		Action       string `default:"sgdicefirst"`
+		SessionTable string `default:"session"`
+		DigestField  string `default:"digest"`
+	}
+	Health struct {
+		MaxBrokerDowntime int `default:"60"` // seconds, 0 disables check
+		MaxEventAge       int `default:"0"`  // seconds, 0 disables check as games can be idle for long
+		MaxHeadBlockAge   int `default:"30"` // seconds, 0 disables check
+	}
+	Snapshots struct {
+		Path      string // snapshots are disabled if path is empty
+		Interval  int    `default:"3600"` // seconds
+		Retention int    `default:"720"`  // hours, 0 means keep forever
+		MaxAmount int    `default:"0"`    // 0 means unlimited
+	}
+}
+
+type RSAKeyEntry struct {
+	ID         string
+	Key        string `secret:"true"` // base64 encoded PEM
+	KeyFile    string // used if Key isn't set, keystore entry "rsa:<ID>" is used if none is set
+	ActivateAt string // RFC3339, empty means active right away
+	RetireAt   string // RFC3339, empty means never retired
+	Games      []string
+}
+
+type RSASchemeEntry struct {
+	Game       string
+	Padding    string // "pkcs1v15" or "pss"
+	Hash       string // "sha256", "sha384" or "sha512"
+	Encoding   string // "base64", "base64url" or "hex"
+	HashDigest bool   // hash event digest before signing
 }
 
 const (
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/DaoCasino/casino-backend/election"
+	"github.com/DaoCasino/casino-backend/offsetstore"
+	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/eoscanada/eos-go/ecc"
+)
+
+const redacted = "<redacted>"
+
+// configValidator collects all config problems instead of stopping at the first one
+type configValidator struct {
+	errors []error
+}
+
+func (v *configValidator) fail(format string, args ...interface{}) {
+	v.errors = append(v.errors, fmt.Errorf(format, args...))
+}
+
+func (v *configValidator) required(name, value string) bool {
+	if value == "" {
+		v.fail("%s is required", name)
+		return false
+	}
+	return true
+}
+
+func (v *configValidator) oneOf(name, value string, allowed ...string) {
+	for _, option := range allowed {
+		if strings.EqualFold(value, option) {
+			return
+		}
+	}
+	v.fail("%s is %q, expected one of %s", name, value, strings.Join(allowed, ", "))
+}
+
+// atLeast checks numeric setting, e.g. interval passed to time.NewTicker which panics on zero
+func (v *configValidator) atLeast(name string, value, min int) {
+	if value < min {
+		v.fail("%s is %d, it must be at least %d", name, value, min)
+	}
+}
+
+// accountName checks name is a valid EOS account name if it's set
+func (v *configValidator) accountName(name, value string) {
+	if value == "" {
+		return
+	}
+	valid := len(value) <= 12 && !strings.HasSuffix(value, ".")
+	for _, c := range value {
+		if !strings.ContainsRune(".12345abcdefghijklmnopqrstuvwxyz", c) {
+			valid = false
+		}
+	}
+	if !valid {
+		v.fail("%s %q isn't a valid account name", name, value)
+	}
+}
+
+func (v *configValidator) privateKey(name, value string) {
+	if v.required(name, value) {
+		if _, err := ecc.NewPrivateKey(value); err != nil {
+			v.fail("%s is malformed: %s", name, err.Error())
+		}
+	}
+}
+
+func (v *configValidator) publicKey(name, value string) {
+	if v.required(name, value) {
+		if _, err := ecc.NewPublicKey(value); err != nil {
+			v.fail("%s is malformed: %s", name, err.Error())
+		}
+	}
+}
+
+// ValidateConfig returns all problems of config which would make service fail or misbehave
+func ValidateConfig(cfg *Config) []error {
+	v := &configValidator{}
+	v.oneOf("server.logFormat", cfg.Server.LogFormat, LogFormatConsole, LogFormatJSON)
+
+	v.required("broker.url", cfg.Broker.URL)
+	v.required("broker.topicOffsetPath", cfg.Broker.TopicOffsetPath)
+	v.oneOf("broker.offsetBackend", cfg.Broker.OffsetBackend, offsetstore.BackendFile, offsetstore.BackendBolt)
+
+	if cfg.BlockChain.URL == "" && len(cfg.BlockChain.URLs) == 0 {
+		v.fail("blockChain.url or blockChain.urls is required")
+	}
+	if v.required("blockChain.chainID", cfg.BlockChain.ChainID) {
+		if chainID, err := hex.DecodeString(cfg.BlockChain.ChainID); err != nil || len(chainID) != 32 {
+			v.fail("blockChain.chainID %q isn't a hex encoded 32 bytes hash", cfg.BlockChain.ChainID)
+		}
+	}
+	for _, account := range []struct{ name, value string }{
+		{"blockChain.signiDiceAccountName", cfg.BlockChain.SigniDiceAccountName},
+		{"blockChain.casinoAccountName", cfg.BlockChain.CasinoAccountName},
+		{"blockChain.platformAccountName", cfg.BlockChain.PlatformAccountName},
+	} {
+		v.required(account.name, account.value)
+		v.accountName(account.name, account.value)
+	}
+	for _, game := range cfg.BlockChain.GameContracts {
+		v.accountName("blockChain.gameContracts", game)
+	}
+	v.publicKey("blockChain.platformPubKey", cfg.BlockChain.PlatformPubKey)
+
+	switch strings.ToLower(cfg.Keys.Source) {
+	case KeySourceConfig:
+		v.privateKey("blockChain.depositKey", cfg.BlockChain.DepositKey)
+		v.privateKey("blockChain.signiDiceKey", cfg.BlockChain.SigniDiceKey)
+		if v.required("blockChain.rsaKey", cfg.BlockChain.RSAKey) {
+			if _, err := utils.ReadRsa(cfg.BlockChain.RSAKey); err != nil {
+				v.fail("blockChain.rsaKey is malformed: %s", err.Error())
+			}
+		}
+	case KeySourceFile:
+		v.required("keys.depositKeyFile", cfg.Keys.DepositKeyFile)
+		v.required("keys.signiDiceKeyFile", cfg.Keys.SigniDiceKeyFile)
+		v.required("keys.rsaKeyFile", cfg.Keys.RSAKeyFile)
+	case KeySourceKeystore:
+		v.required("keys.keystorePath", cfg.Keys.KeystorePath)
+	case KeySourceRemote:
+		v.required("keys.remoteSignerURL", cfg.Keys.RemoteSignerURL)
+		v.atLeast("keys.remoteSignerTimeout", cfg.Keys.RemoteSignerTimeout, 1)
+		v.publicKey("keys.depositPubKey", cfg.Keys.DepositPubKey)
+		v.publicKey("keys.signiDicePubKey", cfg.Keys.SigniDicePubKey)
+	default:
+		v.oneOf("keys.source", cfg.Keys.Source, KeySourceConfig, KeySourceFile, KeySourceKeystore, KeySourceRemote)
+	}
+
+	v.atLeast("blockChain.healthCheckInterval", cfg.BlockChain.HealthCheckInterval, 1)
+	v.atLeast("blockChain.requestTimeout", cfg.BlockChain.RequestTimeout, 1)
+	v.atLeast("http.retryAmount", cfg.HTTP.RetryAmount, 1)
+	v.atLeast("chainState.pollInterval", cfg.ChainState.PollInterval, 1)
+	if cfg.Snapshots.Path != "" {
+		v.atLeast("snapshots.interval", cfg.Snapshots.Interval, 1)
+	}
+	if cfg.Fallback.CursorPath != "" {
+		v.atLeast("fallback.pollInterval", cfg.Fallback.PollInterval, 1)
+		v.atLeast("fallback.maxBlocks", cfg.Fallback.MaxBlocks, 1)
+		// failover retries broker with this delay
+		v.atLeast("broker.reconnectionDelay", cfg.Broker.ReconnectionDelay, 1)
+	}
+
+	v.oneOf("chainState.refBlock", cfg.ChainState.RefBlock, RefBlockLIB, RefBlockHead)
+	v.oneOf("selfCheck.mode", cfg.SelfCheck.Mode, SelfCheckStrict, SelfCheckDegraded, SelfCheckOff)
+	for _, entry := range cfg.RSA.Keys {
+		v.required("rsa.keys.id", entry.ID)
+		for _, game := range entry.Games {
+			v.accountName("rsa.keys.games", game)
+		}
+	}
+	for _, entry := range cfg.RSA.Schemes {
+		v.accountName("rsa.schemes.game", entry.Game)
+		if _, err := utils.ParseRsaScheme(entry.Padding, entry.Hash, entry.Encoding, entry.HashDigest); err != nil {
+			v.fail("rsa.schemes of %s is malformed: %s", entry.Game, err.Error())
+		}
+	}
+	if cfg.Tracing.Exporter != "" {
+		v.oneOf("tracing.exporter", cfg.Tracing.Exporter, TracingExporterOTLP, TracingExporterFile)
+		v.atLeast("tracing.flushInterval", cfg.Tracing.FlushInterval, 1)
+	}
+	if cfg.Election.Backend != "" {
+		v.oneOf("election.backend", cfg.Election.Backend, election.BackendFile, election.BackendMemory)
+		v.atLeast("election.leaseTTL", cfg.Election.LeaseTTL, 1)
+	}
+	return v.errors
+}
+
+// RedactConfig returns copy of config with fields tagged as secret replaced
+func RedactConfig(cfg *Config) (*Config, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	redactedCfg := &Config{}
+	if err := json.Unmarshal(data, redactedCfg); err != nil {
+		return nil, err
+	}
+	redact(reflect.ValueOf(redactedCfg).Elem())
+	return redactedCfg, nil
+}
+
+func redact(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Field(i)
+			if value.Type().Field(i).Tag.Get("secret") != "true" {
+				redact(field)
+				continue
+			}
+			switch field.Kind() {
+			case reflect.String:
+				if field.String() != "" {
+					field.SetString(redacted)
+				}
+			case reflect.Map:
+				// e.g. auth headers
+				for _, key := range field.MapKeys() {
+					field.SetMapIndex(key, reflect.ValueOf(redacted))
+				}
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			redact(value.Index(i))
+		}
+	}
+}
+
+func runConfigCommand(configPath string, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("unknown config subcommand, use check")
+	}
+	cfg, err := GetConfig(configPath)
+	if err != nil {
+		return err
+	}
+	redactedCfg, err := RedactConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if err := toml.NewEncoder(out).Encode(redactedCfg); err != nil {
+		return err
+	}
+	if errs := ValidateConfig(cfg); len(errs) > 0 {
+		return configErrors(errs)
+	}
+	_, err = fmt.Fprintln(out, "\n# config is valid")
+	return err
+}
+
+// configErrors joins problems of config into a single error
+func configErrors(errs []error) error {
+	problems := make([]string, len(errs))
+	for i, err := range errs {
+		problems[i] = err.Error()
+	}
+	return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+}
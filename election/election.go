@@ -0,0 +1,126 @@
+// Package election elects a single leader among replicas sharing a lease, so that only one of them processes events
+package election
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BackendFile   = "file"
+	BackendMemory = "memory"
+)
+
+// DefaultTTL is used if ttl given to elector is too short to renew lease
+const DefaultTTL = 10 * time.Second
+
+// Lease is held by at most one holder at a time until it's released or expires
+type Lease interface {
+	// Acquire takes lease or renews it for ttl, returns false if it's held by another holder
+	Acquire(holder string, ttl time.Duration) (bool, error)
+	// Release gives lease up if it's held by holder
+	Release(holder string) error
+}
+
+// Elector campaigns for lease and runs leader work while it's held
+type Elector struct {
+	lease  Lease
+	id     string
+	ttl    time.Duration
+	leader int32
+	// OnChange is called when replica becomes leader or steps down
+	OnChange func(leader bool)
+}
+
+// NewElector makes elector renewing lease every third of ttl, so that a failed renewal is noticed before expiration
+func NewElector(lease Lease, id string, ttl time.Duration) *Elector {
+	if ttl/3 <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Elector{lease: lease, id: id, ttl: ttl}
+}
+
+func (e *Elector) ID() string {
+	return e.id
+}
+
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+func (e *Elector) setLeader(leader bool) {
+	value := int32(0)
+	if leader {
+		value = 1
+	}
+	if atomic.SwapInt32(&e.leader, value) != value && e.OnChange != nil {
+		e.OnChange(leader)
+	}
+}
+
+// term is a leader work run while lease is held
+type term struct {
+	stop context.CancelFunc
+	done chan error
+}
+
+func (e *Elector) startTerm(ctx context.Context, lead func(ctx context.Context) error) *term {
+	ctx, stop := context.WithCancel(ctx)
+	t := &term{stop: stop, done: make(chan error, 1)}
+	e.setLeader(true)
+	go func() {
+		t.done <- lead(ctx)
+	}()
+	return t
+}
+
+// Run campaigns until ctx is done. Once lease is acquired lead is called, its ctx is cancelled
+// if lease can't be renewed. Lease is released when lead returns, so that other replica takes over at once.
+// Returns lead error, campaign failures are reported to onError.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context) error, onError func(err error)) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	var current *term
+	endTerm := func(err error) error {
+		current = nil
+		e.setLeader(false)
+		if releaseErr := e.lease.Release(e.id); releaseErr != nil {
+			onError(releaseErr)
+		}
+		return err
+	}
+	for {
+		acquired, err := e.lease.Acquire(e.id, e.ttl)
+		if err != nil {
+			onError(err)
+		}
+		switch {
+		case acquired && current == nil:
+			current = e.startTerm(ctx, lead)
+		case !acquired && current != nil:
+			// another replica may be leading already
+			current.stop()
+			if err := endTerm(<-current.done); err != nil {
+				return err
+			}
+		}
+
+		var done chan error
+		if current != nil {
+			done = current.done
+		}
+		select {
+		case <-ctx.Done():
+			if current == nil {
+				return nil
+			}
+			current.stop()
+			return endTerm(<-current.done)
+		case err := <-done:
+			// leader work isn't supposed to end by itself
+			return endTerm(err)
+		case <-ticker.C:
+		}
+	}
+}
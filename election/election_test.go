@@ -0,0 +1,151 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLease(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "election")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	lease, err := Open(BackendFile, filepath.Join(dir, "lease"))
+	assert.Nil(err)
+
+	acquired, err := lease.Acquire("first", time.Hour)
+	assert.Nil(err)
+	assert.True(acquired)
+	// holder renews, others wait
+	acquired, err = lease.Acquire("first", 50*time.Millisecond)
+	assert.Nil(err)
+	assert.True(acquired)
+	acquired, err = lease.Acquire("second", time.Hour)
+	assert.Nil(err)
+	assert.False(acquired)
+
+	// expired lease is taken over
+	time.Sleep(60 * time.Millisecond)
+	acquired, err = lease.Acquire("second", time.Hour)
+	assert.Nil(err)
+	assert.True(acquired)
+
+	// only holder releases
+	assert.Nil(lease.Release("first"))
+	acquired, _ = lease.Acquire("first", time.Hour)
+	assert.False(acquired)
+	assert.Nil(lease.Release("second"))
+	acquired, _ = lease.Acquire("first", time.Hour)
+	assert.True(acquired)
+
+	_, err = Open("zookeeper", "")
+	assert.NotNil(err)
+}
+
+func TestElector(t *testing.T) {
+	assert := assert.New(t)
+	lease := NewMemoryLease()
+	var leading int32
+	lead := func(ctx context.Context) error {
+		assert.Equal(int32(1), atomic.AddInt32(&leading, 1), "single leader at a time")
+		<-ctx.Done()
+		atomic.AddInt32(&leading, -1)
+		return nil
+	}
+	run := func(id string) (*Elector, context.CancelFunc, chan error) {
+		elector := NewElector(lease, id, 30*time.Millisecond)
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- elector.Run(ctx, lead, func(err error) { assert.Nil(err) })
+		}()
+		return elector, stop, done
+	}
+	waitLeader := func(id string) {
+		for i := 0; i < 100 && lease.Holder() != id; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(id, lease.Holder())
+	}
+
+	first, stopFirst, firstDone := run("first")
+	waitLeader("first")
+	second, stopSecond, secondDone := run("second")
+	time.Sleep(50 * time.Millisecond)
+	assert.True(first.IsLeader())
+	assert.False(second.IsLeader())
+
+	// leader releases lease on exit, standby takes over
+	stopFirst()
+	assert.Nil(<-firstDone)
+	assert.False(first.IsLeader())
+	waitLeader("second")
+	for i := 0; i < 100 && !second.IsLeader(); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.True(second.IsLeader())
+	stopSecond()
+	assert.Nil(<-secondDone)
+	assert.Equal("", lease.Holder())
+}
+
+func TestElectorStepsDown(t *testing.T) {
+	assert := assert.New(t)
+	lease := NewMemoryLease()
+	elector := NewElector(lease, "first", 30*time.Millisecond)
+	var changes []bool
+	elector.OnChange = func(leader bool) { changes = append(changes, leader) }
+	terms := make(chan struct{}, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- elector.Run(context.Background(), func(ctx context.Context) error {
+			terms <- struct{}{}
+			<-ctx.Done()
+			return nil
+		}, func(err error) {})
+	}()
+	<-terms
+
+	// lease is stolen, e.g. after a long pause, so lead is stopped
+	lease.Lock()
+	lease.record = record{Holder: "second", ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+	lease.Unlock()
+	// and taken back after expiration
+	select {
+	case <-terms:
+	case <-time.After(time.Second):
+		t.Fatal("lease isn't taken back")
+	}
+	assert.Equal([]bool{true, false, true}, changes)
+
+	// failed leader work stops campaign
+	elector = NewElector(NewMemoryLease(), "first", 30*time.Millisecond)
+	failure := errors.New("failure")
+	assert.Equal(failure, elector.Run(context.Background(), func(ctx context.Context) error {
+		return failure
+	}, func(err error) {}))
+	assert.False(elector.IsLeader())
+}
+
+func TestElectorDefaultTTL(t *testing.T) {
+	assert := assert.New(t)
+	for _, ttl := range []time.Duration{0, 2, -time.Second} {
+		lease := NewMemoryLease()
+		elector := NewElector(lease, "first", ttl)
+		ctx, cancel := context.WithCancel(context.Background())
+		assert.Nil(elector.Run(ctx, func(ctx context.Context) error {
+			cancel()
+			<-ctx.Done()
+			return nil
+		}, func(err error) {}))
+		assert.Equal(DefaultTTL, elector.ttl)
+	}
+}
@@ -0,0 +1,139 @@
+package election
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Open makes lease of the backend, path is used by file backend only
+func Open(backend, path string) (Lease, error) {
+	switch backend {
+	case BackendFile:
+		if path == "" {
+			return nil, fmt.Errorf("lease file path isn't set")
+		}
+		return NewFileLease(path), nil
+	case BackendMemory:
+		return NewMemoryLease(), nil
+	}
+	return nil, fmt.Errorf("unknown lease backend %q", backend)
+}
+
+type record struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// take updates record if it's free, expired or held by holder
+func (r *record) take(holder string, ttl time.Duration, now time.Time) bool {
+	if r.Holder != "" && r.Holder != holder && now.Before(r.ExpiresAt) {
+		return false
+	}
+	r.Holder, r.ExpiresAt = holder, now.Add(ttl)
+	return true
+}
+
+// FileLease keeps lease record in a file on storage shared by replicas.
+// Record is updated under exclusive flock, expiration relies on replica clocks being in sync.
+type FileLease struct {
+	path string
+}
+
+func NewFileLease(path string) *FileLease {
+	return &FileLease{path: path}
+}
+
+// update reads record, changes it with f and writes it back if f returns true, all under file lock
+func (l *FileLease) update(f func(r *record) bool) (bool, error) {
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	// closing file releases the lock
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return false, fmt.Errorf("failed to lock %s: %s", l.path, err.Error())
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return false, err
+	}
+	r := &record{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, r); err != nil {
+			// record is rewritten by the next holder
+			r = &record{}
+		}
+	}
+	if !f(r) {
+		return false, nil
+	}
+	if data, err = json.Marshal(r); err != nil {
+		return false, err
+	}
+	if err := file.Truncate(0); err != nil {
+		return false, err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return false, err
+	}
+	return true, file.Sync()
+}
+
+func (l *FileLease) Acquire(holder string, ttl time.Duration) (bool, error) {
+	return l.update(func(r *record) bool {
+		return r.take(holder, ttl, time.Now())
+	})
+}
+
+func (l *FileLease) Release(holder string) error {
+	_, err := l.update(func(r *record) bool {
+		if r.Holder != holder {
+			return false
+		}
+		*r = record{}
+		return true
+	})
+	return err
+}
+
+// MemoryLease is shared by electors of a single process, e.g. for single host setups and tests
+type MemoryLease struct {
+	sync.Mutex
+	record record
+}
+
+func NewMemoryLease() *MemoryLease {
+	return &MemoryLease{}
+}
+
+func (l *MemoryLease) Acquire(holder string, ttl time.Duration) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.record.take(holder, ttl, time.Now()), nil
+}
+
+func (l *MemoryLease) Release(holder string) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.record.Holder == holder {
+		l.record = record{}
+	}
+	return nil
+}
+
+// Holder returns current holder, empty if lease is free
+func (l *MemoryLease) Holder() string {
+	l.Lock()
+	defer l.Unlock()
+	if time.Now().After(l.record.ExpiresAt) {
+		return ""
+	}
+	return l.record.Holder
+}
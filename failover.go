@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	broker "github.com/DaoCasino/platform-action-monitor-client"
+	"github.com/rs/zerolog/log"
+)
+
+// FailoverListener delivers broker events and switches to chain poller once broker has been unavailable
+// longer than threshold, until broker subscription is made again. Broker is unavailable if subscription fails
+// or client gives up reconnecting, a closed client can't be reused, so a new one is made by newBroker.
+type FailoverListener struct {
+	newBroker  func(events chan<- *broker.EventMessage) EventListener
+	poller     *ChainPoller
+	events     chan<- *broker.EventMessage
+	after      time.Duration
+	retryDelay time.Duration
+	wake       chan struct{}
+	fallback   int32
+	// OnBrokerChange is called when broker subscription is made or lost
+	OnBrokerChange func(subscribed bool)
+
+	m           sync.Mutex
+	client      EventListener // nil while a new client isn't made yet
+	topic       broker.EventType
+	offset      uint64 // broker offset to resume from
+	subscribed  bool   // subscription is requested
+	brokerUp    bool   // subscription is made by client
+	subscribing bool
+	downSince   time.Time
+}
+
+func NewFailoverListener(newBroker func(events chan<- *broker.EventMessage) EventListener, poller *ChainPoller,
+	events chan<- *broker.EventMessage, after, retryDelay time.Duration) *FailoverListener {
+	return &FailoverListener{newBroker: newBroker, poller: poller, events: events, after: after,
+		retryDelay: retryDelay, wake: make(chan struct{}, 1)}
+}
+
+// ListenAndServe does nothing as broker connections are made by Run
+func (f *FailoverListener) ListenAndServe(ctx context.Context) error {
+	return nil
+}
+
+// Subscribe requests subscription made as soon as broker is available, so it never fails
+func (f *FailoverListener) Subscribe(eventType broker.EventType, offset uint64) (bool, error) {
+	f.m.Lock()
+	f.topic, f.offset, f.subscribed = eventType, offset, true
+	if !f.brokerUp {
+		f.downSince = time.Now()
+	}
+	f.m.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+func (f *FailoverListener) Unsubscribe(eventType broker.EventType) (bool, error) {
+	f.m.Lock()
+	client, up := f.client, f.brokerUp
+	f.subscribed, f.brokerUp = false, false
+	f.m.Unlock()
+	_, _ = f.poller.Unsubscribe(eventType)
+	f.setFallback(false)
+	if !up {
+		return true, nil
+	}
+	f.brokerChanged(false)
+	return client.Unsubscribe(eventType)
+}
+
+// Fallback returns true if events are polled from chain
+func (f *FailoverListener) Fallback() bool {
+	return atomic.LoadInt32(&f.fallback) == 1
+}
+
+// Run keeps broker client running and polls chain while broker is unavailable until ctx is done
+func (f *FailoverListener) Run(ctx context.Context) {
+	go f.poller.Run(ctx)
+	for {
+		f.runBroker(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.retryDelay):
+		}
+	}
+}
+
+// runBroker forwards events of a new broker client until it gives up reconnecting or ctx is done
+func (f *FailoverListener) runBroker(ctx context.Context) {
+	events := make(chan *broker.EventMessage)
+	client := f.newBroker(events)
+	clientCtx, stopClient := context.WithCancel(ctx)
+	defer stopClient()
+	go client.Run(clientCtx)
+	f.m.Lock()
+	f.client = client
+	f.m.Unlock()
+
+	ticker := time.NewTicker(f.retryDelay)
+	defer ticker.Stop()
+	for {
+		f.check()
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-events:
+			if !ok {
+				log.Warn().Msg("Broker client gave up reconnecting")
+				f.dropClient()
+				return
+			}
+			f.forward(ctx, message)
+		case <-f.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// check makes broker subscription if it's requested and switches to chain poller if it fails for too long
+func (f *FailoverListener) check() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if !f.subscribed || f.brokerUp {
+		return
+	}
+	if !f.subscribing && f.client != nil {
+		f.subscribing = true
+		go f.subscribe(f.client, f.topic, f.offset)
+	}
+	if !f.Fallback() && time.Since(f.downSince) > f.after {
+		_, _ = f.poller.Subscribe(f.topic, f.offset)
+		f.setFallback(true)
+	}
+}
+
+// subscribe is run in background as subscription waits for client to connect
+func (f *FailoverListener) subscribe(client EventListener, topic broker.EventType, offset uint64) {
+	ok, err := client.Subscribe(topic, offset)
+	if err == nil && !ok {
+		err = fmt.Errorf("subscription is rejected")
+	}
+	f.m.Lock()
+	f.subscribing = false
+	if err != nil || f.client != client {
+		f.m.Unlock()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to subscribe to broker events")
+		}
+		return
+	}
+	if !f.subscribed {
+		// unsubscribed meanwhile
+		f.m.Unlock()
+		_, _ = client.Unsubscribe(topic)
+		return
+	}
+	f.brokerUp = true
+	f.m.Unlock()
+	_, _ = f.poller.Unsubscribe(topic)
+	f.setFallback(false)
+	f.brokerChanged(true)
+}
+
+func (f *FailoverListener) dropClient() {
+	f.m.Lock()
+	up := f.brokerUp
+	f.client, f.brokerUp = nil, false
+	if up {
+		f.downSince = time.Now()
+	}
+	f.m.Unlock()
+	if up {
+		f.brokerChanged(false)
+	}
+}
+
+// forward passes broker message to processor, events after it are requested from a new client
+func (f *FailoverListener) forward(ctx context.Context, message *broker.EventMessage) {
+	f.m.Lock()
+	if message.Offset+1 > f.offset {
+		f.offset = message.Offset + 1
+	}
+	f.m.Unlock()
+	f.poller.Follow()
+	select {
+	case f.events <- message:
+	case <-ctx.Done():
+	}
+}
+
+func (f *FailoverListener) setFallback(fallback bool) {
+	value := int32(0)
+	if fallback {
+		value = 1
+	}
+	if atomic.SwapInt32(&f.fallback, value) == value {
+		return
+	}
+	metrics.ChainFallback.Set(float64(value))
+	if fallback {
+		log.Warn().Dur("after", f.after).Msg("Broker is unavailable, polling chain for events")
+	} else {
+		log.Info().Msg("Stopped polling chain for events")
+	}
+}
+
+func (f *FailoverListener) brokerChanged(subscribed bool) {
+	if f.OnBrokerChange != nil {
+		f.OnBrokerChange(subscribed)
+	}
+}
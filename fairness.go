@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/eoscanada/eos-go"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	signidiceRecordsDir = "signidice"
+	rsaKeysDir          = "keys"
+	recordFileExt       = ".json"
+)
+
+type FairnessConfig struct {
+	Path string // fairness records are disabled if path is empty
+}
+
+// SignidiceRecord holds everything needed to verify signidice result of a game session
+type SignidiceRecord struct {
+	Contract       eos.AccountName `json:"contract"`
+	RequestID      uint64          `json:"req_id"`
+	Digest         eos.Checksum256 `json:"digest"`
+	Signature      string          `json:"signature"` // encoded according to scheme
+	Scheme         string          `json:"scheme"`    // padding-hash-encoding, e.g. pkcs1v15-sha256-base64
+	KeyID          string          `json:"key_id"`
+	PubKeyPEM      string          `json:"pubkey_pem"`
+	ContractPubKey string          `json:"contract_pubkey"` // RSA public key as stored in contract
+	TrxID          string          `json:"trx_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// RSAKeyRecord is a public part of RSA key ever used for signidice
+type RSAKeyRecord struct {
+	ID          string    `json:"id"`
+	PubKeyPEM   string    `json:"pubkey_pem"`
+	FirstUsedAt time.Time `json:"first_used_at"`
+}
+
+// SignidiceStorage keeps every record as a separate json file named by request ID in contract directory
+type SignidiceStorage struct {
+	dir string
+}
+
+func NewSignidiceStorage(dir string) (*SignidiceStorage, error) {
+	for _, subdir := range []string{signidiceRecordsDir, rsaKeysDir} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &SignidiceStorage{dir: dir}, nil
+}
+
+// isValidContractName returns true if name survives encoding, so names like "." or ".." can't escape records dir
+func isValidContractName(name eos.AccountName) bool {
+	value, err := eos.StringToName(string(name))
+	return err == nil && name != "" && eos.NameToString(value) == string(name)
+}
+
+func (s *SignidiceStorage) recordFileName(contract eos.AccountName, reqID uint64) (string, error) {
+	if !isValidContractName(contract) {
+		return "", fmt.Errorf("invalid contract name %q", contract)
+	}
+	return filepath.Join(s.dir, signidiceRecordsDir, string(contract), strconv.FormatUint(reqID, 10)+recordFileExt), nil
+}
+
+func (s *SignidiceStorage) keyFileName(id string) string {
+	return filepath.Join(s.dir, rsaKeysDir, id+recordFileExt)
+}
+
+// writeFile writes to temp file first to never expose partially written record
+func writeFile(name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func readFile(name string, payload interface{}) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, payload)
+}
+
+// Save stores record and remembers its key
+func (s *SignidiceStorage) Save(record *SignidiceRecord) error {
+	name, err := s.recordFileName(record.Contract, record.RequestID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := writeFile(name, record); err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.keyFileName(record.KeyID)); !os.IsNotExist(err) {
+		return err
+	}
+	return writeFile(s.keyFileName(record.KeyID),
+		&RSAKeyRecord{ID: record.KeyID, PubKeyPEM: record.PubKeyPEM, FirstUsedAt: record.CreatedAt})
+}
+
+// Load returns os.ErrNotExist error if there's no record
+func (s *SignidiceStorage) Load(contract eos.AccountName, reqID uint64) (*SignidiceRecord, error) {
+	name, err := s.recordFileName(contract, reqID)
+	if err != nil {
+		return nil, err
+	}
+	record := new(SignidiceRecord)
+	if err := readFile(name, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Keys returns every key ever used sorted by first usage
+func (s *SignidiceStorage) Keys() ([]RSAKeyRecord, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, rsaKeysDir))
+	if err != nil {
+		return nil, err
+	}
+	keys := []RSAKeyRecord{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), recordFileExt) {
+			continue
+		}
+		var key RSAKeyRecord
+		if err := readFile(filepath.Join(s.dir, rsaKeysDir, file.Name()), &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].FirstUsedAt.Before(keys[j].FirstUsedAt) })
+	return keys, nil
+}
+
+func (app *App) saveSignidiceRecord(logger *zerolog.Logger, contract eos.AccountName, reqID uint64,
+	digest eos.Checksum256, signature *DigestSignature, trxID string) {
+	if app.SignidiceStorage == nil {
+		return
+	}
+	pem, err := utils.EncodeRsaPublicKeyPEM(&signature.Key.Key.PublicKey)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encode RSA public key")
+		return
+	}
+	record := &SignidiceRecord{
+		Contract:       contract,
+		RequestID:      reqID,
+		Digest:         digest,
+		Signature:      signature.Encoded(),
+		Scheme:         signature.Scheme.String(),
+		KeyID:          signature.Key.ID,
+		PubKeyPEM:      pem,
+		ContractPubKey: signature.ContractKey,
+		TrxID:          trxID,
+		CreatedAt:      time.Now(),
+	}
+	if err := app.SignidiceStorage.Save(record); err != nil {
+		logger.Error().Err(err).Msg("Failed to save signidice record")
+	}
+}
+
+func (app *App) GetSignidiceRecord(writer ResponseWriter, req *Request) {
+	if app.SignidiceStorage == nil {
+		respondWithError(writer, http.StatusNotFound, "fairness records are disabled")
+		return
+	}
+	vars := mux.Vars(req)
+	if !isValidContractName(eos.AN(vars["contract"])) {
+		respondWithError(writer, http.StatusBadRequest, "invalid contract")
+		return
+	}
+	reqID, err := strconv.ParseUint(vars["req_id"], 10, 64)
+	if err != nil {
+		respondWithError(writer, http.StatusBadRequest, "invalid req_id")
+		return
+	}
+	record, err := app.SignidiceStorage.Load(eos.AN(vars["contract"]), reqID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			respondWithError(writer, http.StatusNotFound, "record not found")
+			return
+		}
+		log.Error().Err(err).Str(FieldEndpoint, "/fairness").Str("contract", vars["contract"]).
+			Uint64(FieldSessionID, reqID).Msg("Failed to load signidice record")
+		respondWithError(writer, http.StatusInternalServerError, "failed to load record")
+		return
+	}
+	respondWithJSON(writer, http.StatusOK, record)
+}
+
+// RSAKeyInfo describes configured RSA key
+type RSAKeyInfo struct {
+	ID         string            `json:"id"`
+	PubKeyPEM  string            `json:"pubkey_pem"`
+	Active     bool              `json:"active"`
+	ActivateAt *time.Time        `json:"activate_at,omitempty"`
+	RetireAt   *time.Time        `json:"retire_at,omitempty"`
+	Games      []eos.AccountName `json:"games,omitempty"`
+}
+
+func (app *App) GetFairnessPubKeys(writer ResponseWriter, req *Request) {
+	now := time.Now()
+	current := []RSAKeyInfo{}
+	for _, key := range app.BlockChain.RSAKeyring.Keys() {
+		pem, err := utils.EncodeRsaPublicKeyPEM(&key.Key.PublicKey)
+		if err != nil {
+			respondWithError(writer, http.StatusInternalServerError, fmt.Sprintf("failed to encode key %s", key.ID))
+			return
+		}
+		info := RSAKeyInfo{ID: key.ID, PubKeyPEM: pem, Active: key.ActiveAt(now), Games: key.Games}
+		if !key.ActivateAt.IsZero() {
+			info.ActivateAt = &key.ActivateAt
+		}
+		if !key.RetireAt.IsZero() {
+			info.RetireAt = &key.RetireAt
+		}
+		current = append(current, info)
+	}
+	historical := []RSAKeyRecord{}
+	if app.SignidiceStorage != nil {
+		var err error
+		if historical, err = app.SignidiceStorage.Keys(); err != nil {
+			log.Error().Err(err).Str(FieldEndpoint, "/fairness/pubkey").Msg("Failed to load RSA keys history")
+			respondWithError(writer, http.StatusInternalServerError, "failed to load keys history")
+			return
+		}
+	}
+	respondWithJSON(writer, http.StatusOK, JSONResponse{"current": current, "historical": historical})
+}
@@ -1,6 +1,6 @@
 module github.com/DaoCasino/casino-backend
 
-go 1.13
+go 1.15
 
 require (
 	github.com/BurntSushi/toml v0.3.1
@@ -10,7 +10,15 @@ require (
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/zerolog v1.18.0
-	github.com/stretchr/testify v1.5.1
-	github.com/zenazn/goji v0.9.0
+	github.com/stretchr/testify v1.7.0
+	go.etcd.io/bbolt v1.3.5
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.opentelemetry.io/proto/otlp v0.9.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+)
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+type HealthConfig struct {
+	MaxBrokerDowntime time.Duration // broker disconnected longer fails readiness, 0 disables check
+	MaxEventAge       time.Duration // no events for longer fails readiness, 0 disables check
+	MaxHeadBlockAge   time.Duration // node head block older than that fails readiness, 0 disables check
+}
+
+// HealthCheck is a result of a single dependency check
+type HealthCheck struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	Details   string `json:"details"`
+	Value     string `json:"value,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+}
+
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+func newHealthReport(checks ...HealthCheck) *HealthReport {
+	report := &HealthReport{Status: HealthStatusOK, Checks: checks}
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = HealthStatusFail
+		}
+	}
+	return report
+}
+
+// healthState tracks runtime state of dependencies which can't be queried on demand
+type healthState struct {
+	sync.Mutex
+	startedAt          time.Time
+	brokerSubscribed   bool
+	brokerChangedAt    time.Time
+	lastEventProcessed time.Time
+}
+
+func newHealthState() *healthState {
+	now := time.Now()
+	return &healthState{startedAt: now, brokerChangedAt: now}
+}
+
+func (s *healthState) setBrokerSubscribed(subscribed bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.brokerSubscribed != subscribed {
+		s.brokerSubscribed = subscribed
+		s.brokerChangedAt = time.Now()
+	}
+}
+
+func (s *healthState) markEventProcessed() {
+	s.Lock()
+	defer s.Unlock()
+	s.lastEventProcessed = time.Now()
+}
+
+func (app *App) setBrokerSubscribed(subscribed bool) {
+	app.health.setBrokerSubscribed(subscribed)
+	if subscribed {
+		metrics.BrokerSubscribed.Set(1)
+	} else {
+		metrics.BrokerSubscribed.Set(0)
+	}
+}
+
+// thresholdCheck fails if value exceeds threshold, zero threshold disables the check
+func thresholdCheck(name string, value, threshold time.Duration, details string) HealthCheck {
+	check := HealthCheck{Name: name, OK: true, Details: details, Value: value.Round(time.Millisecond).String()}
+	if threshold > 0 {
+		check.Threshold = threshold.String()
+		check.OK = value <= threshold
+	}
+	return check
+}
+
+func (app *App) checkBroker() HealthCheck {
+	if app.isStandby() {
+		return HealthCheck{Name: "broker", OK: true, Details: "standby, events are processed by the leader"}
+	}
+	app.health.Lock()
+	subscribed, since := app.health.brokerSubscribed, time.Since(app.health.brokerChangedAt)
+	app.health.Unlock()
+	if !subscribed && app.Failover != nil && app.Failover.Fallback() {
+		return HealthCheck{Name: "broker", OK: true, Details: "broker is unavailable, events are polled from chain",
+			Value: since.Round(time.Millisecond).String()}
+	}
+	if subscribed {
+		return HealthCheck{Name: "broker", OK: true, Details: "subscribed to broker events",
+			Value: since.Round(time.Millisecond).String()}
+	}
+	return thresholdCheck("broker", since, app.Health.MaxBrokerDowntime, "not subscribed to broker events")
+}
+
+func (app *App) checkLastEvent() HealthCheck {
+	if app.isStandby() {
+		return HealthCheck{Name: "last_event", OK: true, Details: "standby, events are processed by the leader"}
+	}
+	app.health.Lock()
+	last, details := app.health.lastEventProcessed, "time since the last processed event"
+	if last.IsZero() {
+		last, details = app.health.startedAt, "no events processed since start"
+	}
+	app.health.Unlock()
+	return thresholdCheck("last_event", time.Since(last), app.Health.MaxEventAge, details)
+}
+
+// checkChainHead uses chain state of tracker, so probes don't query node
+func (app *App) checkChainHead() HealthCheck {
+	state := app.ChainTracker.State()
+	if state == nil {
+		return HealthCheck{Name: "chain_head", OK: false, Details: "chain state isn't fetched yet"}
+	}
+	info := state.Info
+	return thresholdCheck("chain_head", time.Since(info.HeadBlockTime.Time), app.Health.MaxHeadBlockAge,
+		fmt.Sprintf("head block %d age", info.HeadBlockNum))
+}
+
+// checkOffsetWritable checks both offset file and its directory, so that file can be replaced as well.
+// Missing offset file isn't created, as empty one is considered corrupted.
+func checkOffsetWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	probe := path + ".probe"
+	f, err = os.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(probe)
+}
+
+func (app *App) checkOffsetFile() HealthCheck {
+	if app.Broker.TopicOffsetPath == "" {
+		return HealthCheck{Name: "offset_file", OK: true, Details: "offset file isn't configured"}
+	}
+	if err := checkOffsetWritable(app.Broker.TopicOffsetPath); err != nil {
+		return HealthCheck{Name: "offset_file", OK: false, Details: err.Error()}
+	}
+	return HealthCheck{Name: "offset_file", OK: true, Details: "offset file is writable"}
+}
+
+func (app *App) checkKeys() HealthCheck {
+	if err := app.keysAvailable(); err != nil {
+		return HealthCheck{Name: "keys", OK: false, Details: err.Error()}
+	}
+	return HealthCheck{Name: "keys", OK: true, Details: "EOS and RSA keys are available"}
+}
+
+func (app *App) keysAvailable() error {
+	if app.Signer == nil {
+		return fmt.Errorf("signer isn't set")
+	}
+	if err := app.Signer.CheckAvailability(); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, game := range app.BlockChain.GameContracts {
+		if len(app.BlockChain.RSAKeyring.Candidates(game, now)) == 0 {
+			return fmt.Errorf("no active RSA key for %s", game)
+		}
+	}
+	for _, key := range app.BlockChain.RSAKeyring.Keys() {
+		if key.ActiveAt(now) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no active RSA key")
+}
+
+// Liveness reports failures which restart is supposed to fix. Dependencies aren't checked,
+// as their failures would make every replica restart, signing included.
+func (app *App) Liveness() *HealthReport {
+	return newHealthReport(HealthCheck{Name: "process", OK: true, Details: "uptime",
+		Value: time.Since(app.health.startedAt).Round(time.Second).String()})
+}
+
+// Readiness reports if service is able to process events and sign requests
+func (app *App) Readiness() *HealthReport {
+	return newHealthReport(
+		app.checkBroker(),
+		app.checkLastEvent(),
+		app.checkChainHead(),
+		app.checkOffsetFile(),
+		app.checkKeys(),
+	)
+}
+
+func respondWithHealth(writer ResponseWriter, report *HealthReport) {
+	code := http.StatusOK
+	if report.Status != HealthStatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	respondWithJSON(writer, code, report)
+}
+
+func (app *App) LiveQuery(writer ResponseWriter, req *Request) {
+	respondWithHealth(writer, app.Liveness())
+}
+
+func (app *App) ReadyQuery(writer ResponseWriter, req *Request) {
+	respondWithHealth(writer, app.Readiness())
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+	"github.com/rs/zerolog/log"
+)
+
+func runKeysCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("keys subcommand isn't set, use rsa-generate, rsa-pubkey or eos-pubkey")
+	}
+	switch args[0] {
+	case "rsa-generate":
+		return runRsaGenerate(args[1:], out)
+	case "rsa-pubkey":
+		return runRsaPubKey(cfg, args[1:], out)
+	case "eos-pubkey":
+		return runEosPubKey(cfg, args[1:], out)
+	}
+	return fmt.Errorf("unknown keys subcommand %q, use rsa-generate, rsa-pubkey or eos-pubkey", args[0])
+}
+
+// runRsaGenerate prints a new RSA key in the form set as blockChain.rsaKey
+func runRsaGenerate(args []string, out io.Writer) error {
+	flags := newFlagSet("keys rsa-generate")
+	bits := flags.Int("bits", 2048, "key size")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, *bits)
+	if err != nil {
+		return err
+	}
+	encoded := utils.EncodeRsa(key)
+	// make sure the service reads it back
+	if _, err := utils.ReadRsa(encoded); err != nil {
+		return fmt.Errorf("generated key can't be read: %s", err.Error())
+	}
+	_, err = fmt.Fprintln(out, encoded)
+	return err
+}
+
+// runRsaPubKey prints public key of RSA key to be stored in game contract
+func runRsaPubKey(cfg *Config, args []string, out io.Writer) error {
+	flags := newFlagSet("keys rsa-pubkey")
+	keyFlags := addRsaKeyFlags(flags)
+	pemFormat := flags.Bool("pem", false, "print PEM instead of base64 encoded DER")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := keyFlags.key(cfg)
+	if err != nil {
+		return err
+	}
+	var encoded string
+	if *pemFormat {
+		encoded, err = utils.EncodeRsaPublicKeyPEM(&key.Key.PublicKey)
+		encoded = strings.TrimSpace(encoded)
+	} else {
+		encoded, err = utils.EncodeRsaPublicKey(&key.Key.PublicKey)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, encoded)
+	return err
+}
+
+// runEosPubKey prints public key of the given WIF or public keys of configured roles
+func runEosPubKey(cfg *Config, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		key, err := ecc.NewPrivateKey(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid private key: %s", err.Error())
+		}
+		_, err = fmt.Fprintln(out, key.PublicKey().String())
+		return err
+	}
+	signer, _, err := LoadKeys(cfg)
+	if err != nil {
+		return err
+	}
+	for _, role := range []KeyRole{KeyRoleDeposit, KeyRoleSigniDice} {
+		pubKey, err := signer.PublicKey(role)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "%s\t%s\n", role, pubKey.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runSignDigest(cfg *Config, args []string, out io.Writer) error {
+	flags := newFlagSet("sign-digest")
+	keyFlags := addRsaKeyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("hex encoded digest isn't given")
+	}
+	digest, err := parseDigest(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	key, err := keyFlags.key(cfg)
+	if err != nil {
+		return err
+	}
+	scheme, err := keyFlags.scheme(cfg)
+	if err != nil {
+		return err
+	}
+	signature, err := scheme.Sign(digest, key.Key)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("key_id", key.ID).Str("scheme", scheme.String()).Msg("Signed digest")
+	_, err = fmt.Fprintln(out, scheme.Encode(signature))
+	return err
+}
+
+func runVerifyDigest(cfg *Config, args []string, out io.Writer) error {
+	flags := newFlagSet("verify-digest")
+	keyFlags := addRsaKeyFlags(flags)
+	pubKey := flags.String("pubkey", "", "public key as stored in game contract, PEM or base64")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("hex encoded digest and signature aren't given")
+	}
+	digest, err := parseDigest(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var key *rsa.PublicKey
+	if *pubKey != "" {
+		if key, err = utils.ParseRsaPublicKey(*pubKey); err != nil {
+			return fmt.Errorf("invalid public key: %s", err.Error())
+		}
+	} else {
+		privateKey, err := keyFlags.key(cfg)
+		if err != nil {
+			return err
+		}
+		key = &privateKey.Key.PublicKey
+	}
+	scheme, err := keyFlags.scheme(cfg)
+	if err != nil {
+		return err
+	}
+	signature, err := scheme.Decode(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid %s signature: %s", scheme.Encoding, err.Error())
+	}
+	if err := scheme.Verify(digest, signature, key); err != nil {
+		return fmt.Errorf("signature is invalid: %s", err.Error())
+	}
+	_, err = fmt.Fprintln(out, "signature is valid")
+	return err
+}
+
+// rsaKeyFlags pick RSA key and signing scheme of game the way the service does,
+// except that key registered in contract isn't checked
+type rsaKeyFlags struct {
+	keyFile *string
+	keyID   *string
+	game    *string
+}
+
+func addRsaKeyFlags(flags *flag.FlagSet) *rsaKeyFlags {
+	return &rsaKeyFlags{
+		keyFile: flags.String("key-file", "", "RSA key file, configured keys are used if empty"),
+		keyID:   flags.String("key-id", "", "ID of configured RSA key, the newest active key of game if empty"),
+		game:    flags.String("game", "", "game contract whose RSA key and signing scheme are used"),
+	}
+}
+
+// key reads RSA key from file if it's given, otherwise takes it from keyring of configured key source
+func (f *rsaKeyFlags) key(cfg *Config) (*RSAKey, error) {
+	if *f.keyFile != "" {
+		if *f.keyID != "" {
+			return nil, fmt.Errorf("-key-file and -key-id can't be used together")
+		}
+		key, err := utils.ReadRsaFile(*f.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &RSAKey{ID: *f.keyFile, Key: key}, nil
+	}
+	keyring, err := LoadRSAKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+	game := eos.AN(*f.game)
+	if *f.keyID == "" {
+		return keyring.Select(game, nil, time.Now())
+	}
+	key, ok := keyring.Get(*f.keyID)
+	if !ok {
+		return nil, fmt.Errorf("RSA key %s isn't configured", *f.keyID)
+	}
+	if game != "" && !key.ServesGame(game) {
+		return nil, fmt.Errorf("RSA key %s isn't mapped to %s", key.ID, game)
+	}
+	return key, nil
+}
+
+// scheme returns configured signing scheme of game
+func (f *rsaKeyFlags) scheme(cfg *Config) (utils.RsaScheme, error) {
+	schemes, err := makeRsaSchemes(cfg)
+	if err != nil {
+		return utils.RsaScheme{}, err
+	}
+	keysConfig := &RSAKeysConfig{Schemes: schemes}
+	return keysConfig.rsaScheme(eos.AN(*f.game)), nil
+}
+
+func parseDigest(encoded string) (eos.Checksum256, error) {
+	digest, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest: %s", err.Error())
+	}
+	if len(digest) != 32 {
+		return nil, fmt.Errorf("invalid digest: %d bytes instead of 32", len(digest))
+	}
+	return digest, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+)
+
+type KeyRole string
+
+const (
+	KeyRoleDeposit   KeyRole = "deposit"
+	KeyRoleSigniDice KeyRole = "signidice"
+)
+
+// TrxPolicy returns error if key of some role must not sign the trx
+type TrxPolicy func(tx *eos.SignedTransaction) error
+
+var keyRolePolicies = map[KeyRole]TrxPolicy{
+	KeyRoleDeposit:   depositTrxPolicy,
+	KeyRoleSigniDice: signidiceTrxPolicy,
+}
+
+// deposit key signs only allowed deposit invariants
+func depositTrxPolicy(tx *eos.SignedTransaction) error {
+	invariant, err := extractInvariant(tx.Actions)
+	if err != nil || !isInvariantAllowed(invariant) {
+		return fmt.Errorf("deposit key can sign only deposit transactions")
+	}
+	return nil
+}
+
+// signidice key signs only sgdicesecond actions
+func signidiceTrxPolicy(tx *eos.SignedTransaction) error {
+	if len(tx.Actions) == 0 {
+		return fmt.Errorf("signidice key can't sign empty transaction")
+	}
+	for _, action := range tx.Actions {
+		if action.Name != eos.ActN("sgdicesecond") {
+			return fmt.Errorf("signidice key can sign only sgdicesecond actions")
+		}
+	}
+	return nil
+}
+
+type roleKey struct {
+	role    KeyRole
+	pubKey  ecc.PublicKey
+	backend eos.Signer
+}
+
+// RoleSigner is an eos.Signer holding keys by role,
+// it refuses to sign trx which doesn't satisfy the policy of signing key role
+type RoleSigner struct {
+	keys map[KeyRole]*roleKey
+}
+
+func NewRoleSigner() *RoleSigner {
+	return &RoleSigner{keys: make(map[KeyRole]*roleKey)}
+}
+
+// Add registers key of role, signing itself is delegated to backend
+func (s *RoleSigner) Add(role KeyRole, pubKey ecc.PublicKey, backend eos.Signer) error {
+	if _, ok := keyRolePolicies[role]; !ok {
+		return fmt.Errorf("no signing policy for key role %s", role)
+	}
+	if _, ok := s.keys[role]; ok {
+		return fmt.Errorf("key for role %s is already set", role)
+	}
+	for _, key := range s.keys {
+		if key.pubKey.String() == pubKey.String() {
+			return fmt.Errorf("key roles %s and %s must use different keys", key.role, role)
+		}
+	}
+	s.keys[role] = &roleKey{role, pubKey, backend}
+	return nil
+}
+
+// AddWIF registers private key of role
+func (s *RoleSigner) AddWIF(role KeyRole, wif string) error {
+	privKey, err := ecc.NewPrivateKey(wif)
+	if err != nil {
+		return fmt.Errorf("invalid %s key: %s", role, err.Error())
+	}
+	bag := eos.NewKeyBag()
+	bag.Keys = append(bag.Keys, privKey)
+	return s.Add(role, privKey.PublicKey(), bag)
+}
+
+func (s *RoleSigner) PublicKey(role KeyRole) (ecc.PublicKey, error) {
+	key, ok := s.keys[role]
+	if !ok {
+		return ecc.PublicKey{}, fmt.Errorf("no key for role %s", role)
+	}
+	return key.pubKey, nil
+}
+
+func (s *RoleSigner) findKey(pubKey ecc.PublicKey) *roleKey {
+	for _, key := range s.keys {
+		if key.pubKey.String() == pubKey.String() {
+			return key
+		}
+	}
+	return nil
+}
+
+func (s *RoleSigner) AvailableKeys() (out []ecc.PublicKey, err error) {
+	for _, key := range s.keys {
+		out = append(out, key.pubKey)
+	}
+	return
+}
+
+// CheckAvailability asks every signing backend if it still holds role key, e.g. remote wallet can get locked
+func (s *RoleSigner) CheckAvailability() error {
+	for role, key := range s.keys {
+		available, err := key.backend.AvailableKeys()
+		if err != nil {
+			return fmt.Errorf("failed to get available %s keys: %s", role, err.Error())
+		}
+		if !containsKey(available, key.pubKey) {
+			return fmt.Errorf("%s key %s isn't available", role, key.pubKey.String())
+		}
+	}
+	return nil
+}
+
+func (s *RoleSigner) ImportPrivateKey(wifPrivKey string) error {
+	return fmt.Errorf("keys without role aren't supported")
+}
+
+func (s *RoleSigner) Sign(tx *eos.SignedTransaction, chainID []byte,
+	requiredKeys ...ecc.PublicKey) (*eos.SignedTransaction, error) {
+	// check every key before signing to never leave trx partially signed
+	keys := make([]*roleKey, len(requiredKeys))
+	for i, pubKey := range requiredKeys {
+		key := s.findKey(pubKey)
+		if key == nil {
+			return nil, fmt.Errorf("private key for %q not found", pubKey)
+		}
+		if err := keyRolePolicies[key.role](tx); err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	for _, key := range keys {
+		var err error
+		if tx, err = key.backend.Sign(tx, chainID, key.pubKey); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
+}
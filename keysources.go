@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/keystore"
+	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+)
+
+const (
+	// KeySourceConfig takes keys right from config values
+	KeySourceConfig = "config"
+	// KeySourceFile reads keys from files readable by owner only
+	KeySourceFile = "file"
+	// KeySourceKeystore decrypts keys from passphrase-encrypted keystore
+	KeySourceKeystore = "keystore"
+	// KeySourceRemote delegates EOS signing to keosd compatible wallet, RSA key is still local
+	KeySourceRemote = "remote"
+	// used if remote signer timeout isn't configured, so a hanging wallet can't block signing forever
+	defaultRemoteSignerTimeout = 5 * time.Second
+
+	keystoreRSAEntry = "rsa"
+)
+
+// remoteSigner signs trxs via keosd /v1/wallet/sign_transaction
+type remoteSigner struct {
+	*eos.WalletSigner
+}
+
+func newRemoteSigner(url, wallet string, timeout time.Duration) *remoteSigner {
+	if timeout <= 0 {
+		timeout = defaultRemoteSignerTimeout
+	}
+	api := eos.New(url)
+	api.HttpClient.Timeout = timeout
+	return &remoteSigner{eos.NewWalletSigner(api, wallet)}
+}
+
+func (s *remoteSigner) Sign(tx *eos.SignedTransaction, chainID []byte,
+	requiredKeys ...ecc.PublicKey) (*eos.SignedTransaction, error) {
+	// wallet doesn't know contract ABIs, so action data must be sent packed
+	for _, action := range tx.Actions {
+		action.SetToServer(true)
+	}
+	return s.WalletSigner.Sign(tx, chainID, requiredKeys...)
+}
+
+// LoadKeys makes role signer and RSA keyring from configured key source
+func LoadKeys(cfg *Config) (*RoleSigner, *RSAKeyring, error) {
+	keys := cfg.Keys
+	signer := NewRoleSigner()
+	var rsaKey *rsa.PrivateKey
+	var secrets map[string]string
+	var err error
+	switch strings.ToLower(keys.Source) {
+	case KeySourceConfig:
+		rsaKey, err = addLocalKeys(signer, cfg.BlockChain.DepositKey, cfg.BlockChain.SigniDiceKey,
+			cfg.BlockChain.RSAKey)
+	case KeySourceFile:
+		rsaKey, err = addFileKeys(signer, cfg)
+	case KeySourceKeystore:
+		if secrets, err = keystore.Open(keys.KeystorePath, keys.KeystorePassphrase); err != nil {
+			return nil, nil, fmt.Errorf("failed to open keystore: %s", err.Error())
+		}
+		rsaKey, err = addLocalKeys(signer, secrets[string(KeyRoleDeposit)], secrets[string(KeyRoleSigniDice)],
+			secrets[keystoreRSAEntry])
+	case KeySourceRemote:
+		rsaKey, err = addRemoteKeys(signer, cfg)
+	default:
+		return nil, nil, fmt.Errorf("unknown key source: %s", keys.Source)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	keyring, err := makeRSAKeyring(cfg, rsaKey, secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, keyring, nil
+}
+
+// readRsa returns nil key if it isn't set
+func readRsa(base64Rsa string) (*rsa.PrivateKey, error) {
+	if base64Rsa == "" {
+		return nil, nil
+	}
+	key, err := utils.ReadRsa(base64Rsa)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSA key: %s", err.Error())
+	}
+	return key, nil
+}
+
+// readRsaFile returns nil key if file isn't set
+func readRsaFile(filename string) (*rsa.PrivateKey, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	return utils.ReadRsaFile(filename)
+}
+
+func addLocalKeys(signer *RoleSigner, depositKey, signidiceKey, base64Rsa string) (*rsa.PrivateKey, error) {
+	if err := signer.AddWIF(KeyRoleDeposit, depositKey); err != nil {
+		return nil, err
+	}
+	if err := signer.AddWIF(KeyRoleSigniDice, signidiceKey); err != nil {
+		return nil, err
+	}
+	return readRsa(base64Rsa)
+}
+
+func addFileKeys(signer *RoleSigner, cfg *Config) (*rsa.PrivateKey, error) {
+	depositKey, err := utils.ReadWIF(cfg.Keys.DepositKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signidiceKey, err := utils.ReadWIF(cfg.Keys.SigniDiceKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := addLocalKeys(signer, depositKey, signidiceKey, ""); err != nil {
+		return nil, err
+	}
+	return readRsaFile(cfg.Keys.RSAKeyFile)
+}
+
+func addRemoteKeys(signer *RoleSigner, cfg *Config) (*rsa.PrivateKey, error) {
+	keys := cfg.Keys
+	backend := newRemoteSigner(keys.RemoteSignerURL, keys.RemoteWallet,
+		time.Duration(keys.RemoteSignerTimeout)*time.Second)
+	available, err := backend.AvailableKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote signer keys: %s", err.Error())
+	}
+	roleKeys := []struct {
+		role   KeyRole
+		pubKey string
+	}{
+		{KeyRoleDeposit, keys.DepositPubKey},
+		{KeyRoleSigniDice, keys.SigniDicePubKey},
+	}
+	for _, roleKey := range roleKeys {
+		pubKey, err := ecc.NewPublicKey(roleKey.pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s public key: %s", roleKey.role, err.Error())
+		}
+		if !containsKey(available, pubKey) {
+			return nil, fmt.Errorf("remote signer has no %s key %s", roleKey.role, pubKey.String())
+		}
+		if err := signer.Add(roleKey.role, pubKey, backend); err != nil {
+			return nil, err
+		}
+	}
+
+	return remoteRsaKey(cfg)
+}
+
+// remoteRsaKey reads local RSA key used along with remote signer, as keosd can't hold RSA keys
+func remoteRsaKey(cfg *Config) (*rsa.PrivateKey, error) {
+	if cfg.Keys.RSAKeyFile != "" {
+		return readRsaFile(cfg.Keys.RSAKeyFile)
+	}
+	return readRsa(cfg.BlockChain.RSAKey)
+}
+
+// LoadRSAKeyring makes RSA keyring from configured key source without loading EOS keys
+func LoadRSAKeyring(cfg *Config) (*RSAKeyring, error) {
+	keys := cfg.Keys
+	var rsaKey *rsa.PrivateKey
+	var secrets map[string]string
+	var err error
+	switch strings.ToLower(keys.Source) {
+	case KeySourceConfig:
+		rsaKey, err = readRsa(cfg.BlockChain.RSAKey)
+	case KeySourceFile:
+		rsaKey, err = readRsaFile(keys.RSAKeyFile)
+	case KeySourceKeystore:
+		if secrets, err = keystore.Open(keys.KeystorePath, keys.KeystorePassphrase); err != nil {
+			return nil, fmt.Errorf("failed to open keystore: %s", err.Error())
+		}
+		rsaKey, err = readRsa(secrets[keystoreRSAEntry])
+	case KeySourceRemote:
+		rsaKey, err = remoteRsaKey(cfg)
+	default:
+		return nil, fmt.Errorf("unknown key source: %s", keys.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return makeRSAKeyring(cfg, rsaKey, secrets)
+}
+
+func containsKey(keys []ecc.PublicKey, key ecc.PublicKey) bool {
+	for _, k := range keys {
+		if k.String() == key.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func parseScheduleTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// makeRSAKeyring combines key from key source with configured rotation keys
+func makeRSAKeyring(cfg *Config, defaultKey *rsa.PrivateKey, secrets map[string]string) (*RSAKeyring, error) {
+	keyring := NewRSAKeyring()
+	if defaultKey != nil {
+		if err := keyring.Add(&RSAKey{ID: DefaultRSAKeyID, Key: defaultKey}); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range cfg.RSA.Keys {
+		key := &RSAKey{ID: entry.ID}
+		var err error
+		switch {
+		case entry.ID == "":
+			return nil, fmt.Errorf("RSA key ID isn't set")
+		case entry.Key != "":
+			key.Key, err = readRsa(entry.Key)
+		case entry.KeyFile != "":
+			key.Key, err = readRsaFile(entry.KeyFile)
+		case secrets != nil:
+			key.Key, err = readRsa(secrets[keystoreRSAEntry+":"+entry.ID])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RSA key %s: %s", entry.ID, err.Error())
+		}
+		if key.ActivateAt, err = parseScheduleTime(entry.ActivateAt); err != nil {
+			return nil, fmt.Errorf("invalid activation time of RSA key %s: %s", entry.ID, err.Error())
+		}
+		if key.RetireAt, err = parseScheduleTime(entry.RetireAt); err != nil {
+			return nil, fmt.Errorf("invalid retirement time of RSA key %s: %s", entry.ID, err.Error())
+		}
+		for _, game := range entry.Games {
+			key.Games = append(key.Games, eos.AN(game))
+		}
+		if err := keyring.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	if len(keyring.Keys()) == 0 {
+		return nil, fmt.Errorf("no RSA keys are set")
+	}
+	return keyring, nil
+}
@@ -0,0 +1,117 @@
+// Package keystore implements passphrase-encrypted local storage of named secrets
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	version = 1
+	kdf     = "scrypt"
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// upper bounds of cost accepted from file, so a tampered one can't exhaust time or memory on start
+	scryptMaxN = 1 << 20
+	scryptMaxR = 16
+	scryptMaxP = 4
+	keyLen     = 32 // AES-256
+	saltLen    = 16
+	fileMode   = 0600
+)
+
+type file struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func newAEAD(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts secrets with passphrase and writes them to path readable by owner only
+func Save(path, passphrase string, secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	f := file{Version: version, KDF: kdf, N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, saltLen)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, f.Salt, f.N, f.R, f.P)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, nil)
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, fileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Open decrypts secrets stored at path
+func Open(path, passphrase string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("malformed keystore: %s", err.Error())
+	}
+	if f.Version != version || f.KDF != kdf {
+		return nil, fmt.Errorf("unsupported keystore version %d with kdf %s", f.Version, f.KDF)
+	}
+	// cost below the one Save uses means the file is weakened
+	if f.N < scryptN || f.N > scryptMaxN || f.R < scryptR || f.R > scryptMaxR || f.P < scryptP || f.P > scryptMaxP {
+		return nil, fmt.Errorf("keystore scrypt parameters n=%d r=%d p=%d are out of bounds", f.N, f.R, f.P)
+	}
+	aead, err := newAEAD(passphrase, f.Salt, f.N, f.R, f.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("malformed keystore nonce")
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted keystore")
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
@@ -0,0 +1,50 @@
+package keystore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeystore(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "keystore")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	secrets := map[string]string{"deposit": "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAbuatmU"}
+	assert.Nil(Save(path, "passphrase", secrets))
+
+	info, err := os.Stat(path)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(fileMode), info.Mode().Perm())
+
+	opened, err := Open(path, "passphrase")
+	assert.Nil(err)
+	assert.Equal(secrets, opened)
+
+	_, err = Open(path, "wrong")
+	assert.Equal("wrong passphrase or corrupted keystore", err.Error())
+
+	// tampered cost is rejected before key derivation
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(err)
+	for _, cost := range [][3]int{{1 << 30, scryptR, scryptP}, {2, scryptR, scryptP}, {scryptN, 1 << 20, scryptP},
+		{scryptN, scryptR, 1 << 20}} {
+		var f file
+		assert.Nil(json.Unmarshal(data, &f))
+		f.N, f.R, f.P = cost[0], cost[1], cost[2]
+		tampered, _ := json.Marshal(f)
+		assert.Nil(ioutil.WriteFile(path, tampered, fileMode))
+		start := time.Now()
+		_, err = Open(path, "passphrase")
+		assert.Contains(err.Error(), "out of bounds")
+		assert.True(time.Since(start) < time.Second)
+	}
+}
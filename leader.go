@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// runEvents processes broker events until ctx is done, if elector is set only while replica is the leader
+func (app *App) runEvents(ctx context.Context) error {
+	if app.Elector == nil {
+		return app.lead(ctx, app.Broker.TopicOffset)
+	}
+	app.Elector.OnChange = app.setLeader
+	log.Info().Str("replica", app.Elector.ID()).Msg("Campaigning for leadership")
+	return app.Elector.Run(ctx, func(ctx context.Context) error {
+		// previous leader could have moved offset since start
+		offset, err := loadOffset(app.Offsets, app.Broker.IgnoreCorruptedOffset)
+		if err != nil {
+			return fmt.Errorf("failed to load offset handed over: %s", err.Error())
+		}
+		return app.lead(ctx, offset)
+	}, func(err error) {
+		log.Warn().Err(err).Msg("Leader election failed")
+	})
+}
+
+// lead subscribes to broker events from offset and processes them until ctx is done
+func (app *App) lead(ctx context.Context, offset uint64) error {
+	if _, err := app.BrokerClient.Subscribe(app.Broker.TopicID, offset); err != nil {
+		app.setBrokerSubscribed(false)
+		return err
+	}
+	// failover listener reports broker subscription itself, as it's made once broker is available
+	if app.Failover == nil {
+		app.setBrokerSubscribed(true)
+	}
+	metrics.BrokerOffset.Set(float64(offset))
+	log.Debug().Uint64("offset", offset).Msg("starting event processor")
+	err := app.RunEventProcessor(ctx)
+	if _, unsubErr := app.BrokerClient.Unsubscribe(app.Broker.TopicID); unsubErr != nil {
+		log.Warn().Err(unsubErr).Msg("Failed to unsubscribe from broker events")
+	}
+	app.setBrokerSubscribed(false)
+	return err
+}
+
+func (app *App) setLeader(leader bool) {
+	if leader {
+		metrics.Leader.Set(1)
+		log.Info().Str("replica", app.Elector.ID()).Msg("Became leader, processing events")
+	} else {
+		metrics.Leader.Set(0)
+		log.Info().Str("replica", app.Elector.ID()).Msg("Stepped down, standing by")
+	}
+}
+
+// isStandby returns true if replica doesn't process events as another one is the leader
+func (app *App) isStandby() bool {
+	return app.Elector != nil && !app.Elector.IsLeader()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// errStopRequested stops intake of new work, it's not reported as Run failure
+var errStopRequested = errors.New("stop requested")
+
+// errEventsClosed is returned by event processor if broker client stopped delivering events
+var errEventsClosed = errors.New("event-monitor isn't responding")
+
+type ShutdownConfig struct {
+	Timeout time.Duration // max time to wait for in-flight events and requests
+}
+
+// inFlight tracks work which must be finished before exit
+type inFlight struct {
+	count int64 // first to be 64-bit aligned for atomic access
+	wg    sync.WaitGroup
+}
+
+func (f *inFlight) add() {
+	atomic.AddInt64(&f.count, 1)
+	f.wg.Add(1)
+}
+
+func (f *inFlight) done() {
+	atomic.AddInt64(&f.count, -1)
+	f.wg.Done()
+}
+
+// wait returns error if some work isn't finished until ctx is done
+func (f *inFlight) wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d events are still in flight", atomic.LoadInt64(&f.count))
+	}
+}
+
+// Stop makes Run stop accepting events and requests, drain in-flight ones and return
+func (app *App) Stop() {
+	app.stopOnce.Do(func() {
+		close(app.stop)
+	})
+}
+
+// commitOffset durably stores offset the broker should resume from
+func (app *App) commitOffset(offset uint64) {
+	app.offsetLock.Lock()
+	defer app.offsetLock.Unlock()
+	if err := app.Offsets.Save(offset); err != nil {
+		log.Error().Err(err).Uint64("offset", offset).Msg("Failed to write offset")
+		return
+	}
+	metrics.BrokerOffset.Set(float64(offset))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/DaoCasino/casino-backend/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"os"
@@ -9,9 +10,35 @@ import (
 	"time"
 )
 
-func InitLogger(level string) {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+)
+
+// log field names shared by call sites, so that a single signidice or deposit can be traced
+const (
+	FieldSessionID = "session_id"
+	FieldSender    = "sender"
+	FieldTrxID     = "trx_id"
+	FieldPlayer    = "player"
+	FieldEndpoint  = "endpoint"
+	FieldAttempt   = "attempt"
+	FieldTraceID   = "trace_id"
+)
 
+func InitLogger(level, format string) {
+	if strings.ToLower(format) == LogFormatJSON {
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	} else {
+		log.Logger = log.Output(newConsoleWriter())
+	}
+	zerolog.SetGlobalLevel(getLevel(level))
+	zerolog.TimestampFunc = func() time.Time {
+		return time.Now().UTC()
+	}
+}
+
+func newConsoleWriter() zerolog.ConsoleWriter {
 	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
 	output.FormatLevel = func(i interface{}) string {
 		const (
@@ -37,14 +64,20 @@ func InitLogger(level string) {
 	output.FormatFieldName = func(i interface{}) string {
 		return fmt.Sprintf("%s:", i)
 	}
+	// values like trx IDs and account names are case sensitive, so they're printed as is
 	output.FormatFieldValue = func(i interface{}) string {
-		return strings.ToUpper(fmt.Sprintf("%s", i))
+		return fmt.Sprintf("%s", i)
 	}
-	log.Logger = log.Output(output)
-	zerolog.SetGlobalLevel(getLevel(level))
-	zerolog.TimestampFunc = func() time.Time {
-		return time.Now().UTC()
+	return output
+}
+
+// withTrace attaches trace ID of span to logger, so that log entries can be matched with traces
+func withTrace(logger zerolog.Logger, span *tracing.Span) zerolog.Logger {
+	traceID := span.TraceID()
+	if traceID == "" {
+		return logger
 	}
+	return logger.With().Str(FieldTraceID, traceID).Logger()
 }
 
 func getLevel(level string) zerolog.Level {
@@ -2,8 +2,9 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"flag"
-	"io"
+	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -11,6 +12,9 @@ import (
 	"github.com/eoscanada/eos-go/ecc"
 
 	"github.com/BurntSushi/toml"
+	"github.com/DaoCasino/casino-backend/election"
+	"github.com/DaoCasino/casino-backend/offsetstore"
+	"github.com/DaoCasino/casino-backend/tracing"
 	"github.com/DaoCasino/casino-backend/utils"
 	broker "github.com/DaoCasino/platform-action-monitor-client"
 	"github.com/eoscanada/eos-go"
@@ -18,46 +22,30 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func MakeAppConfig(cfg *Config) (*AppConfig, *eos.KeyBag, error) {
+func MakeAppConfig(cfg *Config) (*AppConfig, *RoleSigner, error) {
 	appCfg := new(AppConfig)
 	var err error
 
 	// set broker config
 	appCfg.Broker.TopicID = cfg.Broker.TopicID
 
-	if f, err := os.Open(cfg.Broker.TopicOffsetPath); err == nil {
-		defer f.Close()
-		appCfg.Broker.TopicOffset, err = utils.ReadOffset(f)
-		if err != nil {
-			if err == io.EOF { // if file empty just set 0
-				appCfg.Broker.TopicOffset = 0
-			} else {
-				return nil, nil, err
-			}
-		}
-	} else {
-		// initial start
-		appCfg.Broker.TopicOffset = 0
-	}
+	appCfg.Broker.TopicOffsetPath = cfg.Broker.TopicOffsetPath
+	appCfg.Broker.IgnoreCorruptedOffset = cfg.Broker.IgnoreCorruptedOffset
 
 	// set blockchain config
-	keyBag := &eos.KeyBag{}
-	if err = keyBag.Add(cfg.BlockChain.DepositKey); err != nil {
+	signer, rsaKeyring, err := LoadKeys(cfg)
+	if err != nil {
 		return nil, nil, err
 	}
-	if err = keyBag.Add(cfg.BlockChain.SigniDiceKey); err != nil {
+	if appCfg.BlockChain.EosPubKeys.Deposit, err = signer.PublicKey(KeyRoleDeposit); err != nil {
 		return nil, nil, err
 	}
-	pubKeys, err := keyBag.AvailableKeys()
-	if err != nil {
+	if appCfg.BlockChain.EosPubKeys.SigniDice, err = signer.PublicKey(KeyRoleSigniDice); err != nil {
 		return nil, nil, err
 	}
 	appCfg.BlockChain.SignerAccountName = eos.AN(cfg.BlockChain.SigniDiceAccountName)
 	appCfg.BlockChain.CasinoAccountName = eos.AN(cfg.BlockChain.CasinoAccountName)
-	appCfg.BlockChain.EosPubKeys = PubKeys{pubKeys[0], pubKeys[1]}
-	if appCfg.BlockChain.RSAKey, err = utils.ReadRsa(cfg.BlockChain.RSAKey); err != nil {
-		return nil, nil, err
-	}
+	appCfg.BlockChain.RSAKeyring = rsaKeyring
 	if appCfg.BlockChain.ChainID, err = hex.DecodeString(cfg.BlockChain.ChainID); err != nil {
 		return nil, nil, err
 	}
@@ -67,72 +55,335 @@ func MakeAppConfig(cfg *Config) (*AppConfig, *eos.KeyBag, error) {
 		return nil, nil, err
 	}
 
+	for _, game := range cfg.BlockChain.GameContracts {
+		appCfg.BlockChain.GameContracts = append(appCfg.BlockChain.GameContracts, eos.AN(game))
+	}
+
 	// set HTTP config
 	appCfg.HTTP.RetryDelay = time.Duration(cfg.HTTP.RetryDelay) * time.Second
 	appCfg.HTTP.Timeout = time.Duration(cfg.HTTP.Timeout) * time.Second
 	appCfg.HTTP.RetryAmount = cfg.HTTP.RetryAmount
-	return appCfg, keyBag, nil
+	appCfg.HTTP.MaxRetryDelay = time.Duration(cfg.HTTP.MaxRetryDelay) * time.Second
+	appCfg.HTTP.RetryJitter = cfg.HTTP.RetryJitter
+	appCfg.Shutdown.Timeout = time.Duration(cfg.Server.ShutdownTimeout) * time.Second
+
+	// set chain state config
+	appCfg.ChainState.PollInterval = time.Duration(cfg.ChainState.PollInterval) * time.Second
+	appCfg.ChainState.MaxStaleness = time.Duration(cfg.ChainState.MaxStaleness) * time.Second
+	appCfg.ChainState.HeadBlockOffset = uint32(cfg.ChainState.HeadBlockOffset)
+	switch strings.ToLower(cfg.ChainState.RefBlock) {
+	case RefBlockLIB, RefBlockHead:
+		appCfg.ChainState.RefBlock = strings.ToLower(cfg.ChainState.RefBlock)
+	default:
+		return nil, nil, fmt.Errorf("unknown ref block strategy: %s", cfg.ChainState.RefBlock)
+	}
+
+	// set self check config
+	switch strings.ToLower(cfg.SelfCheck.Mode) {
+	case SelfCheckStrict, SelfCheckDegraded, SelfCheckOff:
+		appCfg.SelfCheck.Mode = strings.ToLower(cfg.SelfCheck.Mode)
+	default:
+		return nil, nil, fmt.Errorf("unknown self check mode: %s", cfg.SelfCheck.Mode)
+	}
+	appCfg.SelfCheck.DepositPermission = eos.PN(cfg.SelfCheck.DepositPermission)
+
+	// set RSA keys config
+	appCfg.RSAKeys.PubKeyTable = cfg.RSA.PubKeyTable
+	appCfg.RSAKeys.PubKeyField = cfg.RSA.PubKeyField
+	appCfg.RSAKeys.PubKeyCacheTTL = time.Duration(cfg.RSA.PubKeyCacheTTL) * time.Second
+	if appCfg.RSAKeys.Schemes, err = makeRsaSchemes(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// set snapshots config
+	appCfg.Snapshots.Path = cfg.Snapshots.Path
+	appCfg.Snapshots.Interval = time.Duration(cfg.Snapshots.Interval) * time.Second
+	appCfg.Snapshots.Retention = time.Duration(cfg.Snapshots.Retention) * time.Hour
+	appCfg.Snapshots.MaxAmount = cfg.Snapshots.MaxAmount
+
+	// set chain polling fallback config
+	appCfg.Fallback.After = time.Duration(cfg.Fallback.After) * time.Second
+	appCfg.Fallback.PollInterval = time.Duration(cfg.Fallback.PollInterval) * time.Second
+	appCfg.Fallback.Rewind = uint32(cfg.Fallback.Rewind)
+	appCfg.Fallback.MaxBlocks = uint32(cfg.Fallback.MaxBlocks)
+	appCfg.Fallback.Action = eos.ActN(cfg.Fallback.Action)
+	appCfg.Fallback.SessionTable = cfg.Fallback.SessionTable
+	appCfg.Fallback.DigestField = cfg.Fallback.DigestField
+
+	// set fairness config
+	appCfg.Fairness.Path = cfg.Fairness.Path
+
+	// set health config
+	appCfg.Health.MaxBrokerDowntime = time.Duration(cfg.Health.MaxBrokerDowntime) * time.Second
+	appCfg.Health.MaxEventAge = time.Duration(cfg.Health.MaxEventAge) * time.Second
+	appCfg.Health.MaxHeadBlockAge = time.Duration(cfg.Health.MaxHeadBlockAge) * time.Second
+
+	// set tracing config
+	appCfg.Tracing = TracingConfig{
+		Exporter:      cfg.Tracing.Exporter,
+		Endpoint:      cfg.Tracing.Endpoint,
+		Headers:       cfg.Tracing.Headers,
+		FilePath:      cfg.Tracing.FilePath,
+		ServiceName:   cfg.Tracing.ServiceName,
+		BatchSize:     cfg.Tracing.BatchSize,
+		FlushInterval: time.Duration(cfg.Tracing.FlushInterval) * time.Second,
+		Timeout:       appCfg.HTTP.Timeout,
+	}
+	return appCfg, signer, nil
+}
+
+func makeRsaSchemes(cfg *Config) (map[eos.AccountName]utils.RsaScheme, error) {
+	schemes := make(map[eos.AccountName]utils.RsaScheme)
+	for _, entry := range cfg.RSA.Schemes {
+		scheme, err := utils.ParseRsaScheme(entry.Padding, entry.Hash, entry.Encoding, entry.HashDigest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA scheme of %s: %s", entry.Game, err.Error())
+		}
+		schemes[eos.AN(entry.Game)] = scheme
+	}
+	return schemes, nil
+}
+
+// loadOffset fails on corrupted offset unless it's explicitly allowed to replay the topic from the start
+func loadOffset(store offsetstore.OffsetStore, ignoreCorrupted bool) (uint64, error) {
+	offset, err := store.Load()
+	if errors.Is(err, offsetstore.ErrCorrupted) && ignoreCorrupted {
+		log.Warn().Err(err).Msg("Ignoring corrupted offset, events are replayed from the start")
+		return 0, nil
+	}
+	return offset, err
+}
+
+// OpenOffsetStore opens offset store and loads offset broker should resume from
+func OpenOffsetStore(cfg *Config) (offsetstore.OffsetStore, uint64, error) {
+	store, err := offsetstore.Open(cfg.Broker.OffsetBackend, cfg.Broker.TopicOffsetPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset, err := loadOffset(store, cfg.Broker.IgnoreCorruptedOffset)
+	if err != nil {
+		store.Close()
+		return nil, 0, fmt.Errorf("failed to load offset from %s: %s", cfg.Broker.TopicOffsetPath, err.Error())
+	}
+	return store, offset, nil
+}
+
+func NewBrokerClient(cfg *Config, events chan<- *broker.EventMessage) *broker.EventListener {
+	client := broker.NewEventListener(cfg.Broker.URL, events)
+	client.ReconnectionAttempts = cfg.Broker.ReconnectionAttempts
+	client.ReconnectionDelay = time.Duration(cfg.Broker.ReconnectionDelay) * time.Second
+	client.SetToken(cfg.Broker.Token)
+	return client
+}
+
+// MakeElector returns nil if leader election is disabled
+func MakeElector(cfg *Config) (*election.Elector, error) {
+	if cfg.Election.Backend == "" {
+		return nil, nil
+	}
+	if cfg.Broker.OffsetBackend == offsetstore.BackendBolt {
+		// bolt database is locked by a single process, so standby can't read offset handed over
+		return nil, fmt.Errorf("leader election requires file offset backend")
+	}
+	lease, err := election.Open(cfg.Election.Backend, cfg.Election.LeasePath)
+	if err != nil {
+		return nil, err
+	}
+	id := cfg.Election.ID
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return election.NewElector(lease, id, time.Duration(cfg.Election.LeaseTTL)*time.Second), nil
+}
+
+func MakeNodePool(cfg *Config, signer *RoleSigner) *NodePool {
+	nodeURLs := cfg.BlockChain.URLs
+	if len(nodeURLs) == 0 {
+		nodeURLs = []string{cfg.BlockChain.URL}
+	}
+	bc := NewNodePool(&NodePoolConfig{
+		URLs:                nodeURLs,
+		HealthCheckInterval: time.Duration(cfg.BlockChain.HealthCheckInterval) * time.Second,
+		MaxHeadBlockLag:     uint32(cfg.BlockChain.MaxHeadBlockLag),
+		RequestTimeout:      time.Duration(cfg.BlockChain.RequestTimeout) * time.Second,
+	})
+	bc.SetSigner(signer)
+	return bc
 }
 
-func MakeApp(cfg *Config) (*App, *os.File, error) {
-	appConfig, keyBag, err := MakeAppConfig(cfg)
+// MakeReplayApp makes app able to process events only. It neither opens offset store nor runs self-check,
+// so that it can be used while the service is running.
+func MakeReplayApp(cfg *Config) (*App, error) {
+	appConfig, signer, err := MakeAppConfig(cfg)
 	if err != nil {
-		log.Panic().Msgf("Failed to process config, reason: %s", err.Error())
+		return nil, fmt.Errorf("failed to process config: %s", err.Error())
+	}
+	app := NewApp(MakeNodePool(cfg, signer), nil, nil, nil, appConfig)
+	app.Signer = signer
+	if appConfig.Fairness.Path != "" {
+		if app.SignidiceStorage, err = NewSignidiceStorage(appConfig.Fairness.Path); err != nil {
+			return nil, err
+		}
+	}
+	return app, nil
+}
+
+func MakeApp(cfg *Config) (*App, error) {
+	appConfig, signer, err := MakeAppConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process config: %s", err.Error())
 	}
 
 	events := make(chan *broker.EventMessage)
-	f, err := os.OpenFile(cfg.Broker.TopicOffsetPath, os.O_WRONLY|os.O_CREATE, 0644)
+	offsets, offset, err := OpenOffsetStore(cfg)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	appConfig.Broker.TopicOffset = offset
+	bc := MakeNodePool(cfg, signer)
 
-	bc := eos.New(cfg.BlockChain.URL)
-	bc.SetSigner(keyBag)
+	newBrokerClient := func(events chan<- *broker.EventMessage) EventListener {
+		return NewBrokerClient(cfg, events)
+	}
+	var failover *FailoverListener
+	var brokerClient EventListener
+	if cfg.Fallback.CursorPath == "" {
+		brokerClient = newBrokerClient(events)
+	} else {
+		// file store holds no open handles, so it isn't closed
+		cursor, err := offsetstore.Open(offsetstore.BackendFile, cfg.Fallback.CursorPath)
+		if err != nil {
+			return nil, err
+		}
+		poller := NewChainPoller(bc, cursor, events, appConfig.BlockChain.GameContracts, &appConfig.Fallback)
+		failover = NewFailoverListener(newBrokerClient, poller, events, appConfig.Fallback.After,
+			time.Duration(cfg.Broker.ReconnectionDelay)*time.Second)
+		brokerClient = failover
+	}
+	app := NewApp(bc, brokerClient, events, offsets, appConfig)
+	app.Signer = signer
+	if failover != nil {
+		app.Failover = failover
+		failover.OnBrokerChange = app.setBrokerSubscribed
+	}
+	if app.Elector, err = MakeElector(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := app.RunSelfCheck(); err != nil {
+		return nil, err
+	}
 
-	brokerClient := broker.NewEventListener(cfg.Broker.URL, events)
-	brokerClient.ReconnectionAttempts = cfg.Broker.ReconnectionAttempts
-	brokerClient.ReconnectionDelay = time.Duration(cfg.Broker.ReconnectionDelay) * time.Second
-	brokerClient.SetToken(cfg.Broker.Token)
-	app := NewApp(bc, brokerClient, events, f, appConfig)
-	return app, f, nil
+	if appConfig.Snapshots.Path != "" {
+		if app.SnapshotStorage, err = NewSnapshotStorage(appConfig.Snapshots.Path); err != nil {
+			return nil, err
+		}
+	}
+	if appConfig.Fairness.Path != "" {
+		if app.SignidiceStorage, err = NewSignidiceStorage(appConfig.Fairness.Path); err != nil {
+			return nil, err
+		}
+	}
+	if app.Tracer, err = NewTracer(&appConfig.Tracing); err != nil {
+		return nil, err
+	}
+	tracing.Init(app.Tracer)
+	return app, nil
 }
 
+// GetConfig reads config from environment overridden by TOML file, empty path means environment only.
+// Unknown keys in file are rejected, so that misspelled options aren't silently ignored.
 func GetConfig(configPath string) (*Config, error) {
 	cfg := &Config{}
 	if err := envconfig.Process("", cfg); err != nil {
 		return nil, err
 	}
-	if _, err := toml.DecodeFile(configPath, cfg); err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
+	if configPath == "" {
+		return cfg, nil
+	}
+	meta, err := toml.DecodeFile(configPath, cfg)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("config file %s isn't found, set empty -config or %s to use environment only",
+			configPath, configEnvVar)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", configPath, err.Error())
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
 		}
+		return nil, fmt.Errorf("unknown keys in %s: %s", configPath, strings.Join(keys, ", "))
 	}
 	return cfg, nil
 }
 
+// resolveConfigPath skips missing config file unless its path is set explicitly by flag or env,
+// so that deployments configured by environment only keep working with the default path
+func resolveConfigPath(path string, explicit bool) string {
+	if !explicit {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return ""
+		}
+	}
+	return path
+}
+
 func main() {
 	configPath := flag.String("config", utils.GetConfigPath(configEnvVar, defaultConfigPath),
-		"config file path")
+		"config file path, environment only config is used if the default one doesn't exist")
+	flag.Usage = printUsage
 	flag.Parse()
+	_, explicit := os.LookupEnv(configEnvVar)
+	flag.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+	})
+	*configPath = resolveConfigPath(*configPath, explicit)
+
+	if flag.NArg() > 0 {
+		// command output is written to stdout, so logs go to stderr
+		InitLogger("info", LogFormatConsole)
+		writer := newConsoleWriter()
+		writer.Out = os.Stderr
+		log.Logger = log.Output(writer)
+		if err := RunCommand(*configPath, flag.Args(), os.Stdout); err != nil {
+			log.Fatal().Err(err).Msgf("Command %s failed", flag.Arg(0))
+		}
+		return
+	}
 
 	cfg, err := GetConfig(*configPath)
 	if err != nil {
-		log.Panic().Msg(err.Error())
+		log.Fatal().Err(err).Msg("Failed to read config")
+	}
+	if errs := ValidateConfig(cfg); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error().Err(err).Msg("Invalid config")
+		}
+		log.Fatal().Err(configErrors(errs)).Msg("Failed to start")
 	}
 	logLevel := cfg.Server.LogLevel
-	InitLogger(cfg.Server.LogLevel)
+	InitLogger(cfg.Server.LogLevel, cfg.Server.LogFormat)
 
 	if strings.ToLower(logLevel) == "debug" {
 		broker.EnableDebugLogging()
 	}
 
-	app, f, err := MakeApp(cfg)
+	app, err := MakeApp(cfg)
 	if err != nil {
-		log.Panic().Msg(err.Error())
+		log.Fatal().Err(err).Msg("Failed to start")
 	}
-	defer f.Close()
 
-	if err := app.Run(utils.GetAddr(cfg.Server.Port)); err != nil {
-		log.Panic().Msg(err.Error())
+	err = app.Run(utils.GetAddr(cfg.Server.Port))
+	if closeErr := app.Offsets.Close(); closeErr != nil {
+		log.Error().Err(closeErr).Msg("Failed to close offset store")
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("Stopped on failure")
 	}
 }
@@ -4,27 +4,52 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/eoscanada/eos-go/ecc"
 
+	"github.com/DaoCasino/casino-backend/election"
+	"github.com/DaoCasino/casino-backend/keystore"
+	"github.com/DaoCasino/casino-backend/metrics"
 	"github.com/DaoCasino/casino-backend/mocks"
+	"github.com/DaoCasino/casino-backend/offsetstore"
+	"github.com/DaoCasino/casino-backend/tracing"
+	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/DaoCasino/casino-backend/utils/retry"
 	broker "github.com/DaoCasino/platform-action-monitor-client"
 	"github.com/eoscanada/eos-go"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var a *App
+var chain *mocks.FakeChain
 
 const (
-	bcURL           = "localhost:8888"
 	depositPk       = "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAbuatmU"
 	signiDicePk     = "5KXQYCyytPBsKoymLuDjmg1MdqeSUmFRiczGe67HdWdvuBggKyS"
 	chainID         = "cda75f235aef76ad91ef0503421514d80d8dbb584cd07178022f0bc7deb964ff"
@@ -33,42 +58,82 @@ const (
 	platformPk      = "5KUc6M7hzDr63kDsn2iLn54X7JpzYyXtUEc5iuqieRkQp4iYYkv"
 )
 
-func MakeTestConfig() (*AppConfig, *eos.KeyBag) {
-	keyBag := eos.KeyBag{}
-	if err := keyBag.Add(depositPk); err != nil {
+func MakeTestConfig() (*AppConfig, *RoleSigner) {
+	signer := NewRoleSigner()
+	if err := signer.AddWIF(KeyRoleDeposit, depositPk); err != nil {
 		panic(err)
 	}
-	if err := keyBag.Add(signiDicePk); err != nil {
+	if err := signer.AddWIF(KeyRoleSigniDice, signiDicePk); err != nil {
 		panic(err)
 	}
-	pubKeys, _ := keyBag.AvailableKeys()
+	depositPubKey, _ := signer.PublicKey(KeyRoleDeposit)
+	signiDicePubKey, _ := signer.PublicKey(KeyRoleSigniDice)
 	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsaKeyring := NewRSAKeyring()
+	if err := rsaKeyring.Add(&RSAKey{ID: DefaultRSAKeyID, Key: rsaKey}); err != nil {
+		panic(err)
+	}
 	platformKey, _ := ecc.NewPrivateKey(platformPk)
 	return &AppConfig{
-		BrokerConfig{0, 0},
+		BrokerConfig{0, 0, "", false},
 		BlockChainConfig{
 			eos.Checksum256(chainID),
 			casinoAccName,
 			casinoAccName,
-			PubKeys{pubKeys[0], pubKeys[1]},
-			rsaKey,
+			PubKeys{depositPubKey, signiDicePubKey},
+			rsaKeyring,
 			platformAccName,
 			platformKey.PublicKey(),
+			nil,
 		},
-		HTTPConfig{3, 3 * time.Second, 3 * time.Second},
-	}, &keyBag
+		HTTPConfig{3, 3 * time.Second, 3 * time.Second, 10 * time.Second, 0},
+		SnapshotsConfig{},
+		ChainStateConfig{time.Second, 10 * time.Second, RefBlockLIB, 0},
+		SelfCheckConfig{SelfCheckStrict, "deposit"},
+		RSAKeysConfig{"global", "rsa_pubkey", time.Minute, nil},
+		FairnessConfig{},
+		TracingConfig{},
+		HealthConfig{time.Minute, 0, 30 * time.Second},
+		ShutdownConfig{5 * time.Second},
+		FallbackConfig{time.Minute, time.Second, 120, 100, eos.ActN("sgdicefirst"), "session", "digest"},
+	}, signer
+}
+
+func defaultRSAKey() *rsa.PrivateKey {
+	key, _ := a.BlockChain.RSAKeyring.Get(DefaultRSAKeyID)
+	return key.Key
+}
+
+// registerRSAKey sets RSA public key expected by contract
+func registerRSAKey(contract string, key *rsa.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+	return chain.SetTableRow(contract, contract, "global", 0,
+		map[string]string{"rsa_pubkey": base64.StdEncoding.EncodeToString(der)})
 }
 
 func TestMain(m *testing.M) {
-	InitLogger("debug")
+	InitLogger("debug", LogFormatConsole)
 	events := make(chan *broker.EventMessage)
 	listener := new(mocks.EventListenerMock)
-	f := &mocks.SafeBuffer{}
-	appCfg, keyBag := MakeTestConfig()
-	bc := eos.New(bcURL)
-	bc.SetSigner(keyBag)
+	f := &mocks.OffsetStoreMock{}
+	appCfg, signer := MakeTestConfig()
+	chain = mocks.NewFakeChain(eos.Checksum256(chainID))
+	node := httptest.NewServer(chain)
+	bc := NewNodePool(&NodePoolConfig{URLs: []string{node.URL}, HealthCheckInterval: time.Second})
+	bc.SetSigner(signer)
 	a = NewApp(bc, listener, events, f, appCfg)
+	a.Signer = signer
+	if err := a.ChainTracker.Refresh(); err != nil {
+		panic(err)
+	}
+	if err := registerRSAKey("dicegame", &defaultRSAKey().PublicKey); err != nil {
+		panic(err)
+	}
 	code := m.Run()
+	node.Close()
 	os.Exit(code)
 }
 
@@ -124,14 +189,18 @@ func TestSignTransactionBadRequest(t *testing.T) {
 
 func TestSignidiceAction(t *testing.T) {
 	assert := assert.New(t)
-	action := NewSigndice("gamesc", "onecasino", 42, "casinosig")
+	action := NewSigndice("gamesc", "onecasino", 42, []byte("casinosig"), utils.DefaultRsaScheme)
 	assert.Equal(eos.AN("gamesc"), action.Account)
 	assert.Equal(eos.ActionName("sgdicesecond"), action.Name)
 	assert.Equal([]eos.PermissionLevel{
 		{Actor: eos.AN("onecasino"), Permission: eos.PN("active")},
 	},
 		action.Authorization)
-	assert.Equal(eos.NewActionData(Signidice{RequestID: 42, Signature: "casinosig"}), action.ActionData)
+	assert.Equal(eos.NewActionData(Signidice{RequestID: 42, Signature: "Y2FzaW5vc2ln"}), action.ActionData)
+
+	scheme, _ := utils.ParseRsaScheme("pss", "sha256", "hex", false)
+	action = NewSigndice("gamesc", "onecasino", 42, []byte("casinosig"), scheme)
+	assert.Equal(eos.NewActionData(Signidice{RequestID: 42, Signature: "636173696e6f736967"}), action.ActionData)
 }
 
 func TestSignidiceTransaction(t *testing.T) {
@@ -139,7 +208,7 @@ func TestSignidiceTransaction(t *testing.T) {
 	dicePubKey := a.BlockChain.EosPubKeys.SigniDice
 	txOpts := &eos.TxOptions{ChainID: eos.Checksum256(chainID)}
 	packedTx, err := GetSigndiceTransaction(a.bcAPI, "gamesc", "onecasino",
-		42, "casinosig", dicePubKey, txOpts)
+		42, []byte("casinosig"), utils.DefaultRsaScheme, dicePubKey, txOpts)
 	assert.Nil(err)
 	signedTx, err := packedTx.Unpack()
 	assert.Nil(err)
@@ -325,3 +394,1498 @@ func TestValidateTransaction(t *testing.T) {
 		a.BlockChain.PlatformPubKey,
 		eos.Checksum256(chainID)))
 }
+
+func TestBonusSnapshotsDeltas(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "snapshots")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	storage, err := NewSnapshotStorage(dir)
+	assert.Nil(err)
+
+	asset := func(amount int64) eos.Asset {
+		return eos.Asset{Amount: eos.Int64(amount), Symbol: eos.EOSSymbol}
+	}
+	stats := func(player string, sessions uint64, volume int64) PlayerStats {
+		return PlayerStats{player, sessions, asset(0), asset(volume), asset(0), asset(0)}
+	}
+	start := time.Unix(1600000000, 0).UTC()
+	first := &BonusSnapshot{
+		Timestamp: start,
+		Stats:     []PlayerStats{stats("alice", 2, 100)},
+		Balances:  []PlayerBalance{{Player: "alice", Balance: asset(50)}},
+	}
+	second := &BonusSnapshot{
+		Timestamp: start.Add(time.Hour),
+		Stats:     []PlayerStats{stats("alice", 5, 300), stats("bob", 1, 10)},
+		Balances:  []PlayerBalance{{Player: "alice", Balance: asset(20)}},
+	}
+	assert.Nil(storage.Save(first))
+	assert.Nil(storage.Save(second))
+
+	found, err := storage.Closest(start.Add(30 * time.Minute))
+	assert.Nil(err)
+	assert.Equal(start, found.Timestamp)
+
+	deltas := CalcBonusDeltas(first, second)
+	assert.Equal(2, len(deltas.Players))
+	assert.Equal("alice", deltas.Players[0].Player)
+	assert.Equal(int64(3), deltas.Players[0].SessionsCreated)
+	assert.Equal(eos.Int64(200), deltas.Players[0].VolumeBonus.Amount)
+	assert.Equal(eos.Int64(-30), deltas.Players[0].Balance.Amount)
+	assert.Equal(int64(4), deltas.Total.SessionsCreated)
+	assert.Equal(eos.Int64(210), deltas.Total.VolumeBonus.Amount)
+
+	// bob's stats row is gone in the third snapshot
+	third := &BonusSnapshot{
+		Timestamp: start.Add(2 * time.Hour),
+		Stats:     []PlayerStats{stats("alice", 5, 300)},
+		Balances:  []PlayerBalance{{Player: "alice", Balance: asset(20)}},
+	}
+	deltas = CalcBonusDeltas(second, third)
+	assert.Equal(2, len(deltas.Players))
+	assert.Equal("bob", deltas.Players[1].Player)
+	assert.Equal(int64(-1), deltas.Players[1].SessionsCreated)
+	assert.Equal(eos.Int64(-10), deltas.Players[1].VolumeBonus.Amount)
+	assert.Equal(int64(-1), deltas.Total.SessionsCreated)
+	assert.Equal(eos.Int64(-10), deltas.Total.VolumeBonus.Amount)
+
+	// only the latest snapshot survives
+	assert.Nil(storage.Prune(start.Add(time.Hour), 0, 1))
+	stamps, err := storage.List()
+	assert.Nil(err)
+	assert.Equal([]time.Time{second.Timestamp}, stamps)
+}
+
+func TestCasinoEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	router := a.GetRouter()
+	query := func(path string, result interface{}) int {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		if response.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(response.Body.Bytes(), result))
+		}
+		return response.Code
+	}
+
+	for _, id := range []uint64{1, 2, 3} {
+		assert.Nil(chain.SetTableRow(casinoAccName, casinoAccName, casinoGamesTable, id,
+			map[string]interface{}{"game_id": id, "contract": "dicegame"}))
+		assert.Nil(chain.SetTableRow(casinoAccName, casinoAccName, casinoGameParamsTable, id,
+			map[string]interface{}{"game_id": id, "params": []uint64{id}}))
+	}
+	var rows []struct {
+		GameID uint64 `json:"game_id"`
+	}
+	assert.Equal(http.StatusOK, query("/admin/casino/games", &rows))
+	assert.Equal(3, len(rows))
+	assert.Equal(http.StatusOK, query("/admin/casino/games?last_id=1", &rows))
+	assert.Equal(2, len(rows))
+	assert.Equal(uint64(2), rows[0].GameID)
+	assert.Equal(http.StatusOK, query("/admin/casino/game_params?last_id=2", &rows))
+	assert.Equal(1, len(rows))
+	assert.Equal(uint64(3), rows[0].GameID)
+	assert.Equal(http.StatusOK, query("/admin/casino/game_params?last_id=3", &rows))
+	assert.Empty(rows)
+	for _, lastID := range []string{"abc", "-1", "18446744073709551615", "18446744073709551616"} {
+		assert.Equal(http.StatusBadRequest, query("/admin/casino/games?last_id="+lastID, &rows), lastID)
+		assert.Equal(http.StatusBadRequest, query("/admin/casino/game_params?last_id="+lastID, &rows), lastID)
+	}
+
+	eosAsset, _ := eos.NewAssetFromString("10.0000 EOS")
+	betAsset, _ := eos.NewAssetFromString("5.0000 BET")
+	chain.Lock()
+	chain.Balances[casinoAccName] = []eos.Asset{eosAsset, betAsset}
+	chain.Unlock()
+	defer func() {
+		chain.Lock()
+		delete(chain.Balances, casinoAccName)
+		chain.Unlock()
+	}()
+	var balance []eos.Asset
+	assert.Equal(http.StatusOK, query("/admin/casino/balance", &balance))
+	assert.Equal([]eos.Asset{eosAsset, betAsset}, balance)
+	assert.Equal(http.StatusOK, query("/admin/casino/balance?symbol=BET", &balance))
+	assert.Equal([]eos.Asset{betAsset}, balance)
+	chain.InjectError("get_currency_balance", eos.APIError{Code: http.StatusInternalServerError}, 1)
+	assert.Equal(http.StatusInternalServerError, query("/admin/casino/balance", &balance))
+
+	// signer account isn't known to chain yet
+	var resources AccountResources
+	assert.Equal(http.StatusInternalServerError, query("/admin/signer/resources", &resources))
+	chain.Lock()
+	chain.Accounts[casinoAccName] = &eos.AccountResp{AccountName: casinoAccName, RAMQuota: 8192, RAMUsage: 4096,
+		CPULimit: eos.AccountResourceLimit{Used: 10, Available: 90, Max: 100}, CoreLiquidBalance: eosAsset}
+	chain.Unlock()
+	defer func() {
+		chain.Lock()
+		delete(chain.Accounts, casinoAccName)
+		chain.Unlock()
+	}()
+	assert.Equal(http.StatusOK, query("/admin/signer/resources", &resources))
+	assert.Equal(eos.AN(casinoAccName), resources.Account)
+	assert.Equal(eos.Int64(8192), resources.RAMQuota)
+	assert.Equal(eos.Int64(4096), resources.RAMUsage)
+	assert.Equal(eos.Int64(90), resources.CPULimit.Available)
+	assert.Equal(eosAsset, resources.Balance)
+}
+
+func TestBonusPlayersEndpointsErrors(t *testing.T) {
+	assert := assert.New(t)
+	router := a.GetRouter()
+	for _, path := range []string{"/admin/bonus_players/stats", "/admin/bonus_players/balance"} {
+		chain.InjectError("get_table_rows", eos.APIError{Code: http.StatusInternalServerError, Message: "boom"}, 1)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		assert.Equal(http.StatusInternalServerError, response.Code, path)
+		var body JSONResponse
+		assert.Nil(json.Unmarshal(response.Body.Bytes(), &body), path)
+		assert.Equal(1, len(body), path)
+		assert.NotContains(body["error"], "%s", path)
+		assert.Contains(body["error"], "failed to get bonus players: ", path)
+	}
+}
+
+func TestNodePoolFailover(t *testing.T) {
+	assert := assert.New(t)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"head_block_num": 100}`))
+	}))
+	defer healthy.Close()
+
+	pool := NewNodePool(&NodePoolConfig{URLs: []string{broken.URL, healthy.URL}, MaxHeadBlockLag: 10})
+	info, err := pool.GetInfo()
+	assert.Nil(err)
+	assert.Equal(uint32(100), info.HeadBlockNum)
+
+	pool.CheckHealth()
+	health := pool.Health()
+	assert.False(health[0].Healthy)
+	assert.True(health[1].Healthy)
+	assert.Equal(uint32(100), health[0].HeadBlockLag)
+	assert.Equal(healthy.URL, pool.ordered()[0].api.BaseURL)
+}
+
+func TestNodePoolRequestTimeout(t *testing.T) {
+	assert := assert.New(t)
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"head_block_num": 100, "transaction_id": "00"}`))
+	}))
+	defer healthy.Close()
+
+	pool := NewNodePool(&NodePoolConfig{URLs: []string{hanging.URL, healthy.URL},
+		RequestTimeout: 20 * time.Millisecond})
+	// hanging node is failed over within the same call and charged for it
+	_, err := pool.PushTransaction(&eos.PackedTransaction{})
+	assert.Nil(err)
+	health := pool.Health()
+	assert.Equal(1, health[0].ConsecutiveFails)
+	assert.Equal(0, health[1].ConsecutiveFails)
+
+	// health check doesn't wait for hanging node forever
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < nodeMaxConsecutiveFails; i++ {
+			pool.CheckHealth()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("health check is blocked by hanging node")
+	}
+	health = pool.Health()
+	assert.False(health[0].Healthy)
+	assert.True(health[1].Healthy)
+	assert.Equal(healthy.URL, pool.ordered()[0].api.BaseURL)
+}
+
+func chainError(code int, name, message string) eos.APIError {
+	apiErr := eos.APIError{Code: EosInternalErrorCode, Message: "Internal Service Error"}
+	apiErr.ErrorStruct.Code = code
+	apiErr.ErrorStruct.Name = name
+	apiErr.ErrorStruct.Details = []eos.APIErrorDetail{{Message: message}}
+	return apiErr
+}
+
+func TestClassifyPushError(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(ClassifyPushError(nil))
+	assert.Equal(PushErrorRetryable, ClassifyPushError(fmt.Errorf("connection refused")).Kind)
+	assert.Equal(PushErrorDuplicate, ClassifyPushError(chainError(3040008, "tx_duplicate", "")).Kind)
+	assert.Equal(PushErrorExpired, ClassifyPushError(chainError(3040005, "expired_tx_exception", "")).Kind)
+	assert.Equal(PushErrorFatal, ClassifyPushError(chainError(3090003, "unsatisfied_authorization", "")).Kind)
+	assert.Equal(PushErrorRetryable, ClassifyPushError(chainError(3080004, "tx_cpu_usage_exceeded", "")).Kind)
+
+	pushErr := ClassifyPushError(chainError(3050003, "eosio_assert_message_exception",
+		"assertion failure with message: session not found"))
+	assert.Equal(PushErrorFatal, pushErr.Kind)
+	assert.Equal("session not found", pushErr.Message)
+	assert.Equal("eosio_assert_message_exception: session not found", pushErr.Error())
+}
+
+func TestSendPackedTrxWithRetries(t *testing.T) {
+	assert := assert.New(t)
+	var responses []eos.APIError
+	pushes := 0
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes++
+		if len(responses) == 0 {
+			_, _ = w.Write([]byte(`{"transaction_id": "00"}`))
+			return
+		}
+		body, _ := json.Marshal(responses[0])
+		responses = responses[1:]
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(body)
+	}))
+	defer node.Close()
+	pool := NewNodePool(&NodePoolConfig{URLs: []string{node.URL}})
+	trx := &eos.PackedTransaction{}
+	policy := retry.Policy{Attempts: 3, InitialDelay: time.Millisecond, AttemptTimeout: time.Second}
+
+	// assertion is not retried
+	responses = []eos.APIError{chainError(3050003, "eosio_assert_message_exception",
+		"assertion failure with message: session not found")}
+	_, err := SendPackedTrxWithRetries(context.Background(), &log.Logger, pool, trx, "old", policy, nil)
+	assert.Equal("eosio_assert_message_exception: session not found", err.Error())
+	assert.Equal(1, pushes)
+
+	// expired trx is rebuilt
+	pushes = 0
+	retries := testutil.ToFloat64(metrics.PushTransactionRetries)
+	responses = []eos.APIError{chainError(3040005, "expired_tx_exception", "expired")}
+	trxID, err := SendPackedTrxWithRetries(context.Background(), &log.Logger, pool, trx, "old", policy,
+		func() (*eos.PackedTransaction, string, error) {
+			return trx, "new", nil
+		})
+	assert.Nil(err)
+	assert.Equal("new", trxID)
+	assert.Equal(2, pushes)
+	assert.Equal(retries+1, testutil.ToFloat64(metrics.PushTransactionRetries))
+
+	// duplicate trx is assumed as pushed
+	duplicates := testutil.ToFloat64(metrics.PushTransactionDuplicates)
+	responses = []eos.APIError{chainError(3040008, "tx_duplicate", "duplicate transaction")}
+	_, err = SendPackedTrxWithRetries(context.Background(), &log.Logger, pool, trx, "old", policy, nil)
+	assert.Nil(err)
+	assert.Equal(duplicates+1, testutil.ToFloat64(metrics.PushTransactionDuplicates))
+
+	// hanging node doesn't outlive ctx
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// server notices client gone only once body is read
+		_, _ = ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = SendPackedTrxWithRetries(ctx, &log.Logger, NewNodePool(&NodePoolConfig{URLs: []string{hanging.URL}}),
+		trx, "old", retry.Policy{Attempts: 1000, InitialDelay: time.Millisecond, AttemptTimeout: 10 * time.Millisecond},
+		nil)
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+
+	// attempt timeout leaves time to fail over from hanging node, which is charged for it
+	pool = NewNodePool(&NodePoolConfig{URLs: []string{hanging.URL, node.URL}})
+	_, err = SendPackedTrxWithRetries(context.Background(), &log.Logger, pool, trx, "old",
+		retry.Policy{Attempts: 3, InitialDelay: time.Millisecond, AttemptTimeout: 50 * time.Millisecond}, nil)
+	assert.Nil(err)
+	health := pool.Health()
+	assert.Equal(1, health[0].ConsecutiveFails)
+	assert.Equal(0, health[1].ConsecutiveFails)
+}
+
+func TestProcessEvent(t *testing.T) {
+	assert := assert.New(t)
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	pushedBefore := len(chain.Pushed())
+
+	trxID := a.processEvent(context.Background(), &broker.Event{Sender: "dicegame", RequestID: 42, Data: data})
+	assert.NotNil(trxID)
+
+	pushed := chain.Pushed()
+	assert.Equal(pushedBefore+1, len(pushed))
+	trx := pushed[len(pushed)-1]
+	assert.Equal(1, len(trx.Actions))
+	assert.Equal(eos.AN("dicegame"), trx.Actions[0].Account)
+	assert.Equal(eos.ActN("sgdicesecond"), trx.Actions[0].Name)
+
+	var action Signidice
+	assert.Nil(eos.UnmarshalBinary(trx.Actions[0].HexData, &action))
+	assert.Equal(uint64(42), action.RequestID)
+	signature, err := base64.StdEncoding.DecodeString(action.Signature)
+	assert.Nil(err)
+	assert.Nil(rsa.VerifyPKCS1v15(&defaultRSAKey().PublicKey, crypto.SHA256, digest, signature))
+
+	pubKeys, err := trx.SignedByKeys(eos.Checksum256(chainID))
+	assert.Nil(err)
+	assert.Equal([]ecc.PublicKey{a.BlockChain.EosPubKeys.SigniDice}, pubKeys)
+}
+
+func TestProcessEventExpiredTrx(t *testing.T) {
+	assert := assert.New(t)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(make([]byte, 32))})
+	pushesBefore := chain.Calls("push_transaction")
+	chain.InjectError("push_transaction", chainError(3040005, "expired_tx_exception", "expired"), 1)
+
+	assert.NotNil(a.processEvent(context.Background(), &broker.Event{Sender: "dicegame", RequestID: 43, Data: data}))
+	assert.Equal(pushesBefore+2, chain.Calls("push_transaction"))
+}
+
+func TestSignQuery(t *testing.T) {
+	assert := assert.New(t)
+	keyBag := eos.KeyBag{}
+	assert.Nil(keyBag.Add(platformPk))
+	assert.Nil(keyBag.Add(signiDicePk))
+	pubKeys, _ := keyBag.AvailableKeys()
+	txn := eos.NewSignedTransaction(eos.NewTransaction([]*eos.Action{
+		{
+			Account: eos.AN("eosio.token"),
+			Name:    eos.ActN("transfer"),
+			Authorization: []eos.PermissionLevel{
+				{Actor: eos.AN("player"), Permission: eos.PN(casinoAccName)},
+			},
+			ActionData: eos.NewActionDataFromHexData([]byte{}),
+		},
+		{
+			Account: eos.AN("dice"),
+			Name:    eos.ActN("newgame"),
+			Authorization: []eos.PermissionLevel{
+				{Actor: eos.AN(platformAccName), Permission: eos.PN("gameaction")},
+			},
+			ActionData: eos.NewActionDataFromHexData([]byte{}),
+		},
+	}, &eos.TxOptions{HeadBlockID: make(eos.Checksum256, 32)}))
+	signedTxn, err := keyBag.Sign(txn, eos.Checksum256(chainID), pubKeys...)
+	assert.Nil(err)
+	rawTransaction, _ := json.Marshal(signedTxn)
+	pushedBefore := len(chain.Pushed())
+
+	request, _ := http.NewRequest("POST", "/sign_transaction", bytes.NewBuffer(rawTransaction))
+	response := httptest.NewRecorder()
+	a.SignQuery(response, request)
+
+	assert.Equal(http.StatusOK, response.Code, response.Body.String())
+	pushed := chain.Pushed()
+	assert.Equal(pushedBefore+1, len(pushed))
+	signers, err := pushed[len(pushed)-1].SignedByKeys(eos.Checksum256(chainID))
+	assert.Nil(err)
+	assert.Contains(signers, a.BlockChain.EosPubKeys.Deposit)
+}
+
+func TestChainTracker(t *testing.T) {
+	assert := assert.New(t)
+	tracker := NewChainTracker(a.bcAPI, &ChainStateConfig{MaxStaleness: time.Minute, RefBlock: RefBlockLIB})
+	_, err := tracker.TxOpts()
+	assert.NotNil(err)
+
+	assert.Nil(tracker.Refresh())
+	txOpts, err := tracker.TxOpts()
+	assert.Nil(err)
+	assert.Equal(mocks.BlockID(990), txOpts.HeadBlockID)
+
+	tracker.RefBlock = RefBlockHead
+	tracker.HeadBlockOffset = 3
+	assert.Nil(tracker.Refresh())
+	txOpts, err = tracker.TxOpts()
+	assert.Nil(err)
+	assert.Equal(mocks.BlockID(997), txOpts.HeadBlockID)
+
+	tracker.MaxStaleness = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	_, err = tracker.TxOpts()
+	assert.NotNil(err)
+
+	// hanging node doesn't freeze refresh for longer than poll interval
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+	tracker = NewChainTracker(NewNodePool(&NodePoolConfig{URLs: []string{hanging.URL}}),
+		&ChainStateConfig{PollInterval: 20 * time.Millisecond, RefBlock: RefBlockLIB})
+	start := time.Now()
+	assert.NotNil(tracker.Refresh())
+	assert.True(time.Since(start) < time.Second)
+}
+
+func TestSelfCheck(t *testing.T) {
+	assert := assert.New(t)
+	permission := func(name string, key ecc.PublicKey) eos.Permission {
+		return eos.Permission{PermName: name, RequiredAuth: eos.Authority{
+			Threshold: 1, Keys: []eos.KeyWeight{{PublicKey: key, Weight: 1}},
+		}}
+	}
+	chain.Lock()
+	chain.Accounts[casinoAccName] = &eos.AccountResp{
+		AccountName: casinoAccName,
+		Permissions: []eos.Permission{
+			permission("active", a.BlockChain.EosPubKeys.SigniDice),
+			permission("deposit", a.BlockChain.EosPubKeys.Deposit),
+		},
+	}
+	chain.Accounts[platformAccName] = &eos.AccountResp{AccountName: platformAccName}
+	chain.Unlock()
+	defer func() {
+		chain.Lock()
+		delete(chain.Accounts, casinoAccName)
+		delete(chain.Accounts, platformAccName)
+		chain.Unlock()
+	}()
+
+	assert.Nil(registerRSAKey(casinoAccName, &defaultRSAKey().PublicKey))
+
+	report := a.CheckConfiguration()
+	assert.True(report.OK(), report.String())
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(registerRSAKey(casinoAccName, &otherKey.PublicKey))
+
+	report = a.CheckConfiguration()
+	assert.False(report.OK())
+	assert.NotNil(a.RunSelfCheck())
+}
+
+func TestRoleSignerSeparation(t *testing.T) {
+	assert := assert.New(t)
+	deposit := a.BlockChain.EosPubKeys.Deposit
+	signidice := a.BlockChain.EosPubKeys.SigniDice
+
+	signidiceTx := func() *eos.SignedTransaction {
+		return eos.NewSignedTransaction(eos.NewTransaction([]*eos.Action{
+			NewSigndice("gamesc", "onecasino", 42, []byte("casinosig"), utils.DefaultRsaScheme),
+		}, &eos.TxOptions{}))
+	}
+	depositTx := func() *eos.SignedTransaction {
+		return eos.NewSignedTransaction(eos.NewTransaction([]*eos.Action{
+			{Account: eos.AN("eosio.token"), Name: eos.ActN("transfer"), ActionData: eos.NewActionDataFromHexData(nil)},
+			{Account: eos.AN("dice"), Name: eos.ActN("newgame"), ActionData: eos.NewActionDataFromHexData(nil)},
+		}, &eos.TxOptions{}))
+	}
+
+	_, err := a.bcAPI.Sign(signidiceTx(), eos.Checksum256(chainID), signidice)
+	assert.Nil(err)
+	_, err = a.bcAPI.Sign(depositTx(), eos.Checksum256(chainID), deposit)
+	assert.Nil(err)
+
+	tx := signidiceTx()
+	_, err = a.bcAPI.Sign(tx, eos.Checksum256(chainID), deposit)
+	assert.Equal(fmt.Errorf("deposit key can sign only deposit transactions"), err)
+	assert.Empty(tx.Signatures)
+
+	tx = depositTx()
+	_, err = a.bcAPI.Sign(tx, eos.Checksum256(chainID), signidice)
+	assert.Equal(fmt.Errorf("signidice key can sign only sgdicesecond actions"), err)
+	assert.Empty(tx.Signatures)
+
+	signer := NewRoleSigner()
+	assert.Nil(signer.AddWIF(KeyRoleDeposit, depositPk))
+	assert.NotNil(signer.AddWIF(KeyRoleSigniDice, depositPk))
+	assert.NotNil(signer.AddWIF(KeyRoleDeposit, signiDicePk))
+	assert.NotNil(signer.AddWIF(KeyRole("unknown"), signiDicePk))
+}
+
+func TestLoadKeys(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "keys")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	base64Rsa := base64.StdEncoding.EncodeToString(rsaPEM)
+	depositPubKey := a.BlockChain.EosPubKeys.Deposit
+	signidicePubKey := a.BlockChain.EosPubKeys.SigniDice
+
+	checkKeys := func(signer *RoleSigner, keyring *RSAKeyring) {
+		pubKey, err := signer.PublicKey(KeyRoleDeposit)
+		assert.Nil(err)
+		assert.Equal(depositPubKey, pubKey)
+		pubKey, err = signer.PublicKey(KeyRoleSigniDice)
+		assert.Nil(err)
+		assert.Equal(signidicePubKey, pubKey)
+		key, ok := keyring.Get(DefaultRSAKeyID)
+		assert.True(ok)
+		assert.True(utils.RsaPublicKeysEqual(&rsaKey.PublicKey, &key.Key.PublicKey))
+	}
+
+	// file source
+	cfg := &Config{}
+	cfg.Keys.Source = KeySourceFile
+	cfg.Keys.DepositKeyFile = filepath.Join(dir, "deposit.key")
+	cfg.Keys.SigniDiceKeyFile = filepath.Join(dir, "signidice.key")
+	cfg.Keys.RSAKeyFile = filepath.Join(dir, "rsa.pem")
+	assert.Nil(ioutil.WriteFile(cfg.Keys.DepositKeyFile, []byte(depositPk+"\n"), 0600))
+	assert.Nil(ioutil.WriteFile(cfg.Keys.SigniDiceKeyFile, []byte(signiDicePk), 0600))
+	assert.Nil(ioutil.WriteFile(cfg.Keys.RSAKeyFile, rsaPEM, 0600))
+	signer, key, err := LoadKeys(cfg)
+	assert.Nil(err)
+	checkKeys(signer, key)
+
+	assert.Nil(os.Chmod(cfg.Keys.SigniDiceKeyFile, 0644))
+	_, _, err = LoadKeys(cfg)
+	assert.Contains(err.Error(), "accessible by group or others")
+
+	// keystore source
+	cfg = &Config{}
+	cfg.Keys.Source = KeySourceKeystore
+	cfg.Keys.KeystorePath = filepath.Join(dir, "keystore.json")
+	cfg.Keys.KeystorePassphrase = "passphrase"
+	assert.Nil(keystore.Save(cfg.Keys.KeystorePath, "passphrase", map[string]string{
+		"deposit": depositPk, "signidice": signiDicePk, "rsa": base64Rsa,
+	}))
+	signer, key, err = LoadKeys(cfg)
+	assert.Nil(err)
+	checkKeys(signer, key)
+
+	cfg.Keys.KeystorePassphrase = "wrong"
+	_, _, err = LoadKeys(cfg)
+	assert.NotNil(err)
+
+	// remote source
+	wallet, err := mocks.NewFakeWallet(depositPk, signiDicePk)
+	assert.Nil(err)
+	walletServer := httptest.NewServer(wallet)
+	defer walletServer.Close()
+	cfg = &Config{}
+	cfg.Keys.Source = KeySourceRemote
+	cfg.Keys.RemoteSignerURL = walletServer.URL
+	cfg.Keys.RemoteWallet = "default"
+	cfg.Keys.DepositPubKey = depositPubKey.String()
+	cfg.Keys.SigniDicePubKey = signidicePubKey.String()
+	cfg.BlockChain.RSAKey = base64Rsa
+	signer, key, err = LoadKeys(cfg)
+	assert.Nil(err)
+	checkKeys(signer, key)
+
+	tx := eos.NewSignedTransaction(eos.NewTransaction([]*eos.Action{
+		NewSigndice("gamesc", "onecasino", 42, []byte("casinosig"), utils.DefaultRsaScheme),
+	}, &eos.TxOptions{}))
+	tx, err = signer.Sign(tx, eos.Checksum256(chainID), signidicePubKey)
+	assert.Nil(err)
+	assert.Equal(1, wallet.Calls("sign_transaction"))
+	pubKeys, err := tx.SignedByKeys(eos.Checksum256(chainID))
+	assert.Nil(err)
+	assert.Equal([]ecc.PublicKey{signidicePubKey}, pubKeys)
+
+	cfg.Keys.DepositPubKey = a.BlockChain.PlatformPubKey.String()
+	_, _, err = LoadKeys(cfg)
+	assert.Contains(err.Error(), "remote signer has no deposit key")
+
+	// hanging wallet doesn't block signing
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+	start := time.Now()
+	_, err = newRemoteSigner(hanging.URL, "default", 20*time.Millisecond).Sign(tx, eos.Checksum256(chainID),
+		signidicePubKey)
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+}
+
+func TestRSAKeyRotation(t *testing.T) {
+	assert := assert.New(t)
+	const game = "rotgame"
+	now := time.Now()
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	retiredKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	otherGameKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	keyring := NewRSAKeyring()
+	assert.Nil(keyring.Add(&RSAKey{ID: "old", Key: oldKey}))
+	assert.Nil(keyring.Add(&RSAKey{ID: "new", Key: newKey, ActivateAt: now.Add(-time.Hour)}))
+	assert.Nil(keyring.Add(&RSAKey{ID: "retired", Key: retiredKey, RetireAt: now.Add(-time.Minute)}))
+	assert.Nil(keyring.Add(&RSAKey{ID: "other", Key: otherGameKey, Games: []eos.AccountName{"othergame"}}))
+	assert.NotNil(keyring.Add(&RSAKey{ID: "old", Key: oldKey}))
+
+	defaultKeyring := a.BlockChain.RSAKeyring
+	a.BlockChain.RSAKeyring = keyring
+	defer func() { a.BlockChain.RSAKeyring = defaultKeyring }()
+
+	registerKey := func(key *rsa.PrivateKey) {
+		assert.Nil(registerRSAKey(game, &key.PublicKey))
+		a.RSAPubKeys.Invalidate(game)
+	}
+	signedBy := func(key *rsa.PrivateKey) {
+		digest := make([]byte, 32)
+		_, _ = rand.Read(digest)
+		data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+		assert.NotNil(a.processEvent(context.Background(), &broker.Event{Sender: game, RequestID: 44, Data: data}))
+		pushed := chain.Pushed()
+		var action Signidice
+		assert.Nil(eos.UnmarshalBinary(pushed[len(pushed)-1].Actions[0].HexData, &action))
+		signature, _ := base64.StdEncoding.DecodeString(action.Signature)
+		assert.Nil(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, signature))
+	}
+
+	// contract still expects old key while the new one is already active
+	registerKey(oldKey)
+	signedBy(oldKey)
+	// contract switched to the new key
+	registerKey(newKey)
+	signedBy(newKey)
+
+	// retired and other game keys are never picked
+	_, err := keyring.Select(game, &retiredKey.PublicKey, now)
+	assert.NotNil(err)
+	_, err = keyring.Select(game, &otherGameKey.PublicKey, now)
+	assert.NotNil(err)
+	key, err := keyring.Select("othergame", &otherGameKey.PublicKey, now)
+	assert.Nil(err)
+	assert.Equal("other", key.ID)
+
+	// newest active key is used if contract key is unknown
+	key, err = keyring.Select(game, nil, now)
+	assert.Nil(err)
+	assert.Equal("new", key.ID)
+}
+
+func TestProcessEventRSAKeyDivergence(t *testing.T) {
+	assert := assert.New(t)
+	const game = "badgame"
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(registerRSAKey(game, &otherKey.PublicKey))
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(make([]byte, 32))})
+	pushesBefore := chain.Calls("push_transaction")
+
+	assert.Nil(a.processEvent(context.Background(), &broker.Event{Sender: game, RequestID: 45, Data: data}))
+	assert.Equal(pushesBefore, chain.Calls("push_transaction"))
+	assert.Equal(float64(1), testutil.ToFloat64(metrics.RSAKeyDivergence.WithLabelValues(game)))
+
+	// contract without registered key can't be verified
+	assert.Nil(a.processEvent(context.Background(), &broker.Event{Sender: "unknowngame", RequestID: 46, Data: data}))
+	assert.Equal(pushesBefore, chain.Calls("push_transaction"))
+}
+
+func TestFairness(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fairness")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	a.SignidiceStorage, err = NewSignidiceStorage(dir)
+	assert.Nil(err)
+	defer func() { a.SignidiceStorage = nil }()
+	router := a.GetRouter()
+
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	trxID := a.processEvent(context.Background(), &broker.Event{Sender: "dicegame", RequestID: 47, Data: data})
+	assert.NotNil(trxID)
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/fairness/dicegame/47", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	var record SignidiceRecord
+	assert.Nil(json.Unmarshal(response.Body.Bytes(), &record))
+	assert.Equal(eos.AN("dicegame"), record.Contract)
+	assert.Equal(uint64(47), record.RequestID)
+	assert.Equal(eos.Checksum256(digest), record.Digest)
+	assert.Equal(*trxID, record.TrxID)
+	assert.Equal(DefaultRSAKeyID, record.KeyID)
+	pubKey, err := utils.ParseRsaPublicKey(record.PubKeyPEM)
+	assert.Nil(err)
+	assert.Nil(utils.RsaVerify(record.Digest, record.Signature, pubKey))
+	contractPubKey, err := utils.ParseRsaPublicKey(record.ContractPubKey)
+	assert.Nil(err)
+	assert.True(utils.RsaPublicKeysEqual(pubKey, contractPubKey))
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/fairness/dicegame/48", nil))
+	assert.Equal(http.StatusNotFound, response.Code)
+
+	// contract names made of dots can't reach files outside of contract dir
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, signidiceRecordsDir, "47"+recordFileExt), []byte("{}"), 0644))
+	for _, contract := range []string{".", "..", "dicegame.", ""} {
+		response = httptest.NewRecorder()
+		a.GetSignidiceRecord(response, mux.SetURLVars(httptest.NewRequest("GET", "/fairness", nil),
+			map[string]string{"contract": contract, "req_id": "47"}))
+		assert.Equal(http.StatusBadRequest, response.Code, contract)
+		_, err = a.SignidiceStorage.Load(eos.AN(contract), 47)
+		assert.NotNil(err)
+		assert.False(os.IsNotExist(err))
+		assert.NotNil(a.SignidiceStorage.Save(&SignidiceRecord{Contract: eos.AN(contract), RequestID: 49}))
+	}
+	_, err = os.Stat(filepath.Join(dir, "49"+recordFileExt))
+	assert.True(os.IsNotExist(err))
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/fairness/pubkey", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	var keys struct {
+		Current    []RSAKeyInfo   `json:"current"`
+		Historical []RSAKeyRecord `json:"historical"`
+	}
+	assert.Nil(json.Unmarshal(response.Body.Bytes(), &keys))
+	assert.Equal(1, len(keys.Current))
+	assert.True(keys.Current[0].Active)
+	assert.Equal(record.PubKeyPEM, keys.Current[0].PubKeyPEM)
+	assert.Equal(1, len(keys.Historical))
+	assert.Equal(DefaultRSAKeyID, keys.Historical[0].ID)
+}
+
+func TestProcessEventRSAScheme(t *testing.T) {
+	assert := assert.New(t)
+	const game = "pssgame"
+	// PSS with sha512 doesn't fit 1024 bit test key
+	scheme, err := utils.ParseRsaScheme("pss", "sha384", "hex", true)
+	assert.Nil(err)
+	a.RSAKeys.Schemes = map[eos.AccountName]utils.RsaScheme{game: scheme}
+	defer func() { a.RSAKeys.Schemes = nil }()
+	assert.Nil(registerRSAKey(game, &defaultRSAKey().PublicKey))
+
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	assert.NotNil(a.processEvent(context.Background(), &broker.Event{Sender: game, RequestID: 48, Data: data}))
+
+	pushed := chain.Pushed()
+	var action Signidice
+	assert.Nil(eos.UnmarshalBinary(pushed[len(pushed)-1].Actions[0].HexData, &action))
+	signature, err := hex.DecodeString(action.Signature)
+	assert.Nil(err)
+	hashed := sha512.Sum384(digest)
+	assert.Nil(rsa.VerifyPSS(&defaultRSAKey().PublicKey, crypto.SHA384, hashed[:], signature,
+		&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}))
+}
+
+func TestStructuredLogging(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	defaultLogger := log.Logger
+	log.Logger = zerolog.New(zerolog.SyncWriter(&buf))
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	trxID := a.processEvent(context.Background(), &broker.Event{Sender: "dicegame", RequestID: 49, Data: data})
+	log.Logger = defaultLogger
+	assert.NotNil(trxID)
+
+	var sent map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		assert.Nil(json.Unmarshal(line, &entry))
+		if entry["message"] == "Successfully sent signidice_part_2 txn" {
+			sent = entry
+		}
+	}
+	assert.NotNil(sent)
+	assert.Equal(float64(49), sent[FieldSessionID])
+	assert.Equal("dicegame", sent[FieldSender])
+	assert.Equal(*trxID, sent[FieldTrxID])
+
+	var console bytes.Buffer
+	writer := newConsoleWriter()
+	writer.Out, writer.NoColor = &console, true
+	consoleLogger := zerolog.New(writer)
+	consoleLogger.Info().Str(FieldTrxID, "abcdef").Msg("test")
+	assert.Contains(console.String(), "trx_id:abcdef")
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+	succeeded := testutil.ToFloat64(metrics.SigniDiceEvents.WithLabelValues(metrics.OutcomeSuccess, ""))
+	unparsed := testutil.ToFloat64(metrics.SigniDiceEvents.WithLabelValues(metrics.OutcomeFailure, "parse_digest"))
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	assert.NotNil(a.processEvent(context.Background(), &broker.Event{Sender: "dicegame", RequestID: 50, Data: data}))
+	assert.Nil(a.processEvent(context.Background(), &broker.Event{Sender: "dicegame", RequestID: 51, Data: []byte(`{"digest": 1}`)}))
+	assert.Equal(succeeded+1, testutil.ToFloat64(metrics.SigniDiceEvents.WithLabelValues(metrics.OutcomeSuccess, "")))
+	assert.Equal(unparsed+1,
+		testutil.ToFloat64(metrics.SigniDiceEvents.WithLabelValues(metrics.OutcomeFailure, "parse_digest")))
+	assert.True(testutil.ToFloat64(metrics.SecondsSinceLastEvent) < 1)
+	assert.True(testutil.CollectAndCount(metrics.BlockchainCallMs) > 0)
+
+	invalid := testutil.ToFloat64(metrics.SignTransactionRequests.WithLabelValues(metrics.OutcomeFailure, "deserialize"))
+	pings := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/ping", "200"))
+	fairnessRoute := "/fairness/{contract:[a-z1-5.]{1,12}}/{req_id:[0-9]+}"
+	notFound := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(fairnessRoute, "404"))
+	router := a.GetRouter()
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/ping", nil),
+		httptest.NewRequest("POST", "/sign_transaction", bytes.NewBufferString("invalid")),
+		httptest.NewRequest("GET", "/fairness/dicegame/404", nil),
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(pings+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/ping", "200")))
+	assert.Equal(invalid+1,
+		testutil.ToFloat64(metrics.SignTransactionRequests.WithLabelValues(metrics.OutcomeFailure, "deserialize")))
+	assert.Equal(notFound+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(fairnessRoute, "404")))
+}
+
+func TestTracing(t *testing.T) {
+	assert := assert.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Init(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer tracing.Init(nil)
+
+	var buf bytes.Buffer
+	defaultLogger := log.Logger
+	log.Logger = zerolog.New(zerolog.SyncWriter(&buf))
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	ctx, receipt := tracing.Start(context.Background(), "broker_receipt")
+	assert.NotNil(a.processEvent(ctx, &broker.Event{Sender: "dicegame", RequestID: 52, Data: data}))
+	receipt.End()
+	log.Logger = defaultLogger
+
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(receipt.TraceID(), span.SpanContext().TraceID().String())
+		names[span.Name()] = span
+	}
+	for _, name := range []string{"signidice_part_2", "parse_digest", "rsa_sign", "get_tx_opts", "build_trx",
+		"push_transaction"} {
+		assert.Contains(names, name)
+	}
+	assert.Equal(names["signidice_part_2"].SpanContext().SpanID(), names["push_transaction"].Parent().SpanID())
+	attempt := false
+	for _, kv := range names["push_transaction"].Attributes() {
+		if string(kv.Key) == FieldAttempt {
+			attempt = true
+			assert.Equal(int64(1), kv.Value.AsInt64())
+		}
+	}
+	assert.True(attempt)
+	assert.Contains(buf.String(), `"trace_id":"`+receipt.TraceID()+`"`)
+
+	// request spans continue trace of the caller
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest("POST", "/sign_transaction", strings.NewReader("invalid"))
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	a.GetRouter().ServeHTTP(httptest.NewRecorder(), req)
+	var query sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "sign_transaction" {
+			query = span
+		}
+	}
+	if assert.NotNil(query) {
+		assert.Equal(traceID, query.SpanContext().TraceID().String())
+		assert.Equal(parentID, query.Parent().SpanID().String())
+		assert.True(query.Parent().IsRemote())
+	}
+}
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+	router := a.GetRouter()
+	query := func(path string) (int, *HealthReport) {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		report := new(HealthReport)
+		assert.Nil(json.Unmarshal(response.Body.Bytes(), report))
+		return response.Code, report
+	}
+	checks := func(report *HealthReport) map[string]HealthCheck {
+		out := make(map[string]HealthCheck)
+		for _, check := range report.Checks {
+			out[check.Name] = check
+		}
+		return out
+	}
+
+	dir, err := ioutil.TempDir("", "health")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	a.Broker.TopicOffsetPath = filepath.Join(dir, "offset")
+	defer func() { a.Broker.TopicOffsetPath = "" }()
+	chain.Lock()
+	chain.Info.HeadBlockTime = eos.BlockTimestamp{Time: time.Now().UTC()}
+	chain.Unlock()
+	assert.Nil(a.ChainTracker.Refresh())
+	a.setBrokerSubscribed(true)
+
+	code, report := query("/health/ready")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(HealthStatusOK, report.Status)
+	var names []string
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+	}
+	assert.Equal([]string{"broker", "last_event", "chain_head", "offset_file", "keys"}, names)
+	assert.Equal("30s", checks(report)["chain_head"].Threshold)
+
+	// stale chain head and unwritable offset file fail readiness only
+	chain.Lock()
+	chain.Info.HeadBlockTime = eos.BlockTimestamp{Time: time.Now().UTC().Add(-time.Minute)}
+	chain.Unlock()
+	assert.Nil(a.ChainTracker.Refresh())
+	a.Broker.TopicOffsetPath = filepath.Join(dir, "missing", "offset")
+	code, report = query("/health/ready")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal(HealthStatusFail, report.Status)
+	assert.False(checks(report)["chain_head"].OK)
+	assert.False(checks(report)["offset_file"].OK)
+	assert.True(checks(report)["keys"].OK)
+	code, _ = query("/health/live")
+	assert.Equal(http.StatusOK, code)
+
+	// broker down for too long fails readiness only, so replicas aren't restarted
+	a.setBrokerSubscribed(false)
+	a.health.Lock()
+	a.health.brokerChangedAt = time.Now().Add(-2 * time.Minute)
+	a.health.Unlock()
+	code, report = query("/health/ready")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.False(checks(report)["broker"].OK)
+	assert.Equal("1m0s", checks(report)["broker"].Threshold)
+	code, _ = query("/health/live")
+	assert.Equal(http.StatusOK, code)
+
+	// readiness doesn't query node
+	calls := chain.Calls("get_info")
+	query("/health/ready")
+	assert.Equal(calls, chain.Calls("get_info"))
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	assert := assert.New(t)
+	events := make(chan *broker.EventMessage)
+	offset := &mocks.OffsetStoreMock{}
+	app := NewApp(a.bcAPI, new(mocks.EventListenerMock), events, offset, a.AppConfig)
+	chain.SetLatency("push_transaction", 300*time.Millisecond)
+	defer chain.SetLatency("push_transaction", 0)
+	pushedBefore := len(chain.Pushed())
+
+	done := make(chan error)
+	go func() {
+		done <- app.Run("127.0.0.1:0")
+	}()
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	events <- &broker.EventMessage{Offset: 7, Events: []*broker.Event{{Sender: "dicegame", RequestID: 53, Data: data}}}
+	for atomic.LoadInt64(&app.inFlight.count) == 0 && len(chain.Pushed()) == pushedBefore {
+		time.Sleep(time.Millisecond)
+	}
+
+	// in-flight event is finished before Run returns
+	app.Stop()
+	assert.Nil(<-done)
+	assert.Equal(pushedBefore+1, len(chain.Pushed()))
+	stored, _ := offset.Load()
+	assert.Equal(uint64(8), stored)
+
+	// closed events channel is a failure
+	events = make(chan *broker.EventMessage)
+	app = NewApp(a.bcAPI, new(mocks.EventListenerMock), events, offset, a.AppConfig)
+	close(events)
+	assert.Equal(errEventsClosed, app.Run("127.0.0.1:0"))
+}
+
+func TestOpenOffsetStore(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "offset")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	cfg := &Config{}
+	cfg.Broker.TopicOffsetPath = filepath.Join(dir, "offset")
+
+	store, offset, err := OpenOffsetStore(cfg)
+	assert.Nil(err)
+	assert.Equal(uint64(0), offset)
+	assert.Nil(store.Save(8))
+	_, offset, err = OpenOffsetStore(cfg)
+	assert.Nil(err)
+	assert.Equal(uint64(8), offset)
+
+	// empty file is left by a crash, it's not the initial start
+	assert.Nil(ioutil.WriteFile(cfg.Broker.TopicOffsetPath, nil, 0644))
+	_, _, err = OpenOffsetStore(cfg)
+	assert.NotNil(err)
+	cfg.Broker.IgnoreCorruptedOffset = true
+	_, offset, err = OpenOffsetStore(cfg)
+	assert.Nil(err)
+	assert.Equal(uint64(0), offset)
+}
+
+func TestLeaderElection(t *testing.T) {
+	assert := assert.New(t)
+	lease := election.NewMemoryLease()
+	offsets := &mocks.OffsetStoreMock{}
+	assert.Nil(offsets.Save(5))
+	start := func(id string) (*App, *mocks.EventListenerMock, chan *broker.EventMessage, chan error) {
+		events := make(chan *broker.EventMessage)
+		listener := new(mocks.EventListenerMock)
+		app := NewApp(a.bcAPI, listener, events, offsets, a.AppConfig)
+		app.Elector = election.NewElector(lease, id, 60*time.Millisecond)
+		done := make(chan error)
+		go func() {
+			done <- app.Run("127.0.0.1:0")
+		}()
+		return app, listener, events, done
+	}
+	waitFor := func(condition func() bool) {
+		for i := 0; i < 200 && !condition(); i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.True(condition())
+	}
+
+	leader, leaderListener, leaderEvents, leaderDone := start("first")
+	waitFor(leader.Elector.IsLeader)
+	standby, standbyListener, _, standbyDone := start("second")
+	time.Sleep(50 * time.Millisecond)
+	assert.False(standby.Elector.IsLeader())
+	assert.Equal([]uint64{5}, leaderListener.Subscriptions())
+	assert.Empty(standbyListener.Subscriptions())
+	assert.Equal(HealthStatusOK, standby.Liveness().Status)
+	assert.Equal(1.0, testutil.ToFloat64(metrics.Leader))
+
+	// leader moves offset and hands it over on exit
+	leaderEvents <- &broker.EventMessage{Offset: 7, Events: []*broker.Event{{Sender: "dicegame", RequestID: 54,
+		Data: []byte(`{"digest": 1}`)}}}
+	leader.Stop()
+	assert.Nil(<-leaderDone)
+	waitFor(standby.Elector.IsLeader)
+	waitFor(func() bool { return len(standbyListener.Subscriptions()) == 1 })
+	assert.Equal([]uint64{8}, standbyListener.Subscriptions())
+
+	standby.Stop()
+	assert.Nil(<-standbyDone)
+	assert.Equal("", lease.Holder())
+	assert.Equal(0.0, testutil.ToFloat64(metrics.Leader))
+}
+
+func TestChainFallback(t *testing.T) {
+	assert := assert.New(t)
+	const game = "dicegame"
+	waitFor := func(condition func() bool) {
+		for i := 0; i < 400 && !condition(); i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.True(condition())
+	}
+	// platform has pushed signidice part 1, so session holds digest of part 2
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	assert.Nil(chain.SetTableRow(game, game, "session", 55,
+		map[string]interface{}{"ses_id": 55, "digest": eos.Checksum256(digest)}))
+	assert.Nil(chain.SetTableRow(game, game, "session", 56,
+		map[string]interface{}{"ses_id": 56, "digest": eos.Checksum256(make([]byte, 32))}))
+	part1 := eos.NewSignedTransaction(eos.NewTransaction([]*eos.Action{{
+		Account:       game,
+		Name:          eos.ActN("sgdicefirst"),
+		Authorization: []eos.PermissionLevel{{Actor: platformAccName, Permission: eos.PN("active")}},
+		ActionData:    eos.NewActionData(Signidice{RequestID: 55, Signature: "platformsig"}),
+	}}, &eos.TxOptions{HeadBlockID: mocks.BlockID(984)}))
+	packed, err := part1.Pack(eos.CompressionNone)
+	assert.Nil(err)
+	chain.AddBlockTransaction(985, packed)
+
+	dir, err := ioutil.TempDir("", "fallback")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	cursor := offsetstore.NewFileStore(filepath.Join(dir, "cursor"))
+	assert.Nil(cursor.Save(980))
+	offsets := &mocks.OffsetStoreMock{}
+	assert.Nil(offsets.Save(5))
+
+	cfg := *a.AppConfig
+	cfg.Broker.TopicOffset = 5
+	cfg.Fallback.After = 50 * time.Millisecond
+	cfg.Fallback.PollInterval = 10 * time.Millisecond
+	events := make(chan *broker.EventMessage)
+	brokerClient := new(mocks.EventListenerMock)
+	brokerClient.SetSubscribeError(fmt.Errorf("broker is down"))
+	brokerEvents := make(chan chan<- *broker.EventMessage, 1)
+	poller := NewChainPoller(a.bcAPI, cursor, events, []eos.AccountName{game}, &cfg.Fallback)
+	failover := NewFailoverListener(func(events chan<- *broker.EventMessage) EventListener {
+		brokerEvents <- events
+		return brokerClient
+	}, poller, events, cfg.Fallback.After, 10*time.Millisecond)
+	app := NewApp(a.bcAPI, failover, events, offsets, &cfg)
+	app.Failover = failover
+	failover.OnBrokerChange = app.setBrokerSubscribed
+	pushedBefore := len(chain.Pushed())
+	done := make(chan error)
+	go func() {
+		done <- app.Run("127.0.0.1:0")
+	}()
+
+	// event is polled from chain once broker is down for too long
+	waitFor(func() bool { return len(chain.Pushed()) > pushedBefore })
+	assert.True(failover.Fallback())
+	assert.Equal(1.0, testutil.ToFloat64(metrics.ChainFallback))
+	check := app.checkBroker()
+	assert.True(check.OK)
+	assert.Equal("broker is unavailable, events are polled from chain", check.Details)
+	var action Signidice
+	assert.Nil(eos.UnmarshalBinary(chain.Pushed()[pushedBefore].Actions[0].HexData, &action))
+	assert.Equal(uint64(55), action.RequestID)
+	signature, err := base64.StdEncoding.DecodeString(action.Signature)
+	assert.Nil(err)
+	assert.Nil(rsa.VerifyPKCS1v15(&defaultRSAKey().PublicKey, crypto.SHA256, digest, signature))
+	waitFor(func() bool {
+		last, _ := cursor.Load()
+		return last == uint64(chain.Info.LastIrreversibleBlockNum)
+	})
+	stored, _ := offsets.Load()
+	assert.Equal(uint64(5), stored, "polled events don't move broker offset")
+
+	// broker is back, subscription resumes from the broker offset
+	brokerClient.SetSubscribeError(nil)
+	waitFor(func() bool { return !failover.Fallback() })
+	assert.Equal([]uint64{5}, brokerClient.Subscriptions())
+	assert.Equal(0.0, testutil.ToFloat64(metrics.ChainFallback))
+	(<-brokerEvents) <- &broker.EventMessage{Offset: 9, Events: []*broker.Event{{Sender: game, RequestID: 56,
+		Data: []byte(`{"digest": "` + hex.EncodeToString(make([]byte, 32)) + `"}`)}}}
+	waitFor(func() bool {
+		stored, _ := offsets.Load()
+		return stored == 10
+	})
+
+	app.Stop()
+	assert.Nil(<-done)
+}
+
+func TestOffsetCommand(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "offset")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	cfg := &Config{}
+	cfg.Broker.OffsetBackend = offsetstore.BackendFile
+	cfg.Broker.TopicOffsetPath = filepath.Join(dir, "offset")
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := commands[args[0]].Run(cfg, args[1:], &out)
+		return out.String(), err
+	}
+
+	out, err := run("offset", "show")
+	assert.Nil(err)
+	assert.Equal("0\n", out)
+	_, err = run("offset", "set", "10")
+	assert.NotNil(err, "moving forward requires force")
+	out, err = run("offset", "set", "-force", "10")
+	assert.Nil(err)
+	assert.Equal("offset changed from 0 to 10\n", out)
+	out, err = run("offset", "set", "7")
+	assert.Nil(err)
+	assert.Equal("offset changed from 10 to 7\n", out)
+	out, _ = run("offset", "show")
+	assert.Equal("7\n", out)
+
+	// corrupted offset can be replaced but isn't shown
+	assert.Nil(ioutil.WriteFile(cfg.Broker.TopicOffsetPath, []byte("garbage"), 0644))
+	_, err = run("offset", "show")
+	assert.NotNil(err)
+	out, err = run("offset", "set", "3")
+	assert.Nil(err)
+	assert.Equal("corrupted offset replaced with 3\n", out)
+
+	_, err = run("offset", "set", "-1")
+	assert.NotNil(err)
+	_, err = run("offset", "rewind")
+	assert.NotNil(err)
+	assert.NotNil(RunCommand("", []string{"deploy"}, ioutil.Discard))
+}
+
+func TestReplayEvents(t *testing.T) {
+	assert := assert.New(t)
+	listener := new(mocks.EventListenerMock)
+	events := make(chan *broker.EventMessage, 3)
+	message := func(offsets ...uint64) *broker.EventMessage {
+		m := &broker.EventMessage{Offset: offsets[len(offsets)-1]}
+		for _, offset := range offsets {
+			m.Events = append(m.Events, &broker.Event{Offset: offset, Sender: "dicegame", RequestID: offset})
+		}
+		return m
+	}
+	// broker may resend events before the requested offset
+	events <- message(3, 4)
+	events <- message(5, 6, 7)
+	events <- message(8)
+	var replayed []uint64
+	last, err := replayEvents(context.Background(), listener, events, 0, 4, 6, time.Second,
+		func(event *broker.Event) error {
+			replayed = append(replayed, event.Offset)
+			return nil
+		})
+	assert.Nil(err)
+	assert.Equal(uint64(6), last)
+	assert.Equal([]uint64{4, 5, 6}, replayed)
+	assert.Equal([]uint64{4}, listener.Subscriptions())
+
+	// range end isn't reached if broker has no more events
+	replayed = nil
+	last, err = replayEvents(context.Background(), listener, events, 0, 8, 20, 50*time.Millisecond,
+		func(event *broker.Event) error {
+			replayed = append(replayed, event.Offset)
+			return nil
+		})
+	assert.Nil(err)
+	assert.Equal(uint64(8), last)
+	assert.Equal([]uint64{8}, replayed)
+
+	var out bytes.Buffer
+	assert.Nil(printEvent(&out)(&broker.Event{Offset: 9, Sender: "dicegame", RequestID: 42,
+		Data: []byte(`{"digest":"00"}`)}))
+	assert.Equal(`{"offset":9,"sender":"dicegame","casino_id":0,"game_id":0,"req_id":42,"event_type":0,`+
+		`"data":{"digest":"00"}}`+"\n", out.String())
+}
+
+func TestReplayHandler(t *testing.T) {
+	assert := assert.New(t)
+	node := httptest.NewServer(chain)
+	defer node.Close()
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	cfg, err := GetConfig("")
+	assert.Nil(err)
+	platformKey, _ := ecc.NewPrivateKey(platformPk)
+	cfg.BlockChain.URL = node.URL
+	cfg.BlockChain.ChainID = chainID
+	cfg.BlockChain.SigniDiceAccountName = casinoAccName
+	cfg.BlockChain.CasinoAccountName = casinoAccName
+	cfg.BlockChain.PlatformAccountName = platformAccName
+	cfg.BlockChain.PlatformPubKey = platformKey.PublicKey().String()
+	cfg.Keys.Source = KeySourceConfig
+	cfg.BlockChain.DepositKey = depositPk
+	cfg.BlockChain.SigniDiceKey = signiDicePk
+	cfg.BlockChain.RSAKey = utils.EncodeRsa(defaultRSAKey())
+	// bolt offset store is locked by the running service
+	cfg.Broker.OffsetBackend = offsetstore.BackendBolt
+	cfg.Broker.TopicOffsetPath = filepath.Join(dir, "offset.db")
+	store, err := offsetstore.Open(cfg.Broker.OffsetBackend, cfg.Broker.TopicOffsetPath)
+	assert.Nil(err)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out bytes.Buffer
+	// self-check would fail as signer account isn't known to chain
+	handle, err := replayHandler(ctx, cfg, &out)
+	assert.Nil(err)
+	pushedBefore := len(chain.Pushed())
+	digest := make([]byte, 32)
+	_, _ = rand.Read(digest)
+	data, _ := json.Marshal(map[string]interface{}{"digest": eos.Checksum256(digest)})
+	assert.Nil(handle(&broker.Event{Offset: 12, Sender: "dicegame", RequestID: 57, Data: data}))
+	assert.Equal(pushedBefore+1, len(chain.Pushed()))
+	fields := strings.Split(strings.TrimSpace(out.String()), "\t")
+	assert.Equal(4, len(fields))
+	assert.Equal([]string{"12", "dicegame", "57"}, fields[:3])
+	assert.Equal(64, len(fields[3]))
+}
+
+func TestKeyCommands(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &Config{}
+	cfg.Keys.Source = KeySourceConfig
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := commands[args[0]].Run(cfg, args[1:], &out)
+		return strings.TrimSpace(out.String()), err
+	}
+
+	encoded, err := run("keys", "rsa-generate", "-bits", "1024")
+	assert.Nil(err)
+	key, err := utils.ReadRsa(encoded)
+	assert.Nil(err)
+	assert.Equal(1024, key.N.BitLen())
+	cfg.BlockChain.RSAKey = encoded
+
+	// public key is accepted the way it's read from contract
+	pubKey, err := run("keys", "rsa-pubkey")
+	assert.Nil(err)
+	parsed, err := utils.ParseRsaPublicKey(pubKey)
+	assert.Nil(err)
+	assert.True(utils.RsaPublicKeysEqual(&key.PublicKey, parsed))
+	pemKey, err := run("keys", "rsa-pubkey", "-pem")
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(pemKey, "-----BEGIN PUBLIC KEY-----"))
+
+	digest := strings.Repeat("ab", 32)
+	signature, err := run("sign-digest", digest)
+	assert.Nil(err)
+	expected, _ := utils.RsaSign(eos.Checksum256(bytes.Repeat([]byte{0xab}, 32)), key)
+	assert.Equal(expected, signature)
+	out, err := run("verify-digest", "-pubkey", pubKey, digest, signature)
+	assert.Nil(err)
+	assert.Equal("signature is valid", out)
+	_, err = run("verify-digest", strings.Repeat("cd", 32), signature)
+	assert.NotNil(err)
+	_, err = run("sign-digest", "abcd")
+	assert.NotNil(err, "digest must be 32 bytes")
+
+	// key file is used instead of configured key
+	dir, err := ioutil.TempDir("", "keys")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "rsa")
+	assert.Nil(ioutil.WriteFile(keyFile, []byte(utils.EncodeRsa(defaultRSAKey())), 0600))
+	signature, err = run("sign-digest", "-key-file", keyFile, digest)
+	assert.Nil(err)
+	_, err = run("verify-digest", digest, signature)
+	assert.NotNil(err)
+	_, err = run("verify-digest", "-key-file", keyFile, digest, signature)
+	assert.Nil(err)
+
+	out, err = run("keys", "eos-pubkey", signiDicePk)
+	assert.Nil(err)
+	assert.Equal(a.BlockChain.EosPubKeys.SigniDice.String(), out)
+	cfg.BlockChain.DepositKey, cfg.BlockChain.SigniDiceKey = depositPk, signiDicePk
+	out, err = run("keys", "eos-pubkey")
+	assert.Nil(err)
+	assert.Equal(fmt.Sprintf("deposit\t%s\nsignidice\t%s", a.BlockChain.EosPubKeys.Deposit,
+		a.BlockChain.EosPubKeys.SigniDice), out)
+
+	// rotated key and scheme of game are used the way signidice is signed
+	rotated, _ := rsa.GenerateKey(rand.Reader, 1024)
+	cfg.RSA.Keys = []RSAKeyEntry{{ID: "rotated", Key: utils.EncodeRsa(rotated),
+		ActivateAt: time.Now().Add(-time.Hour).Format(time.RFC3339), Games: []string{"pssgame"}}}
+	cfg.RSA.Schemes = []RSASchemeEntry{{Game: "pssgame", Padding: "pss", Hash: "sha256", Encoding: "hex"}}
+	signature, err = run("sign-digest", "-game", "pssgame", digest)
+	assert.Nil(err)
+	raw, err := hex.DecodeString(signature)
+	assert.Nil(err)
+	scheme, _ := utils.ParseRsaScheme("pss", "sha256", "hex", false)
+	assert.Nil(scheme.Verify(bytes.Repeat([]byte{0xab}, 32), raw, &rotated.PublicKey))
+	_, err = run("verify-digest", "-game", "pssgame", digest, signature)
+	assert.Nil(err)
+	_, err = run("verify-digest", "-game", "pssgame", "-key-id", DefaultRSAKeyID, digest, signature)
+	assert.NotNil(err)
+	_, err = run("verify-digest", digest, signature)
+	assert.NotNil(err, "default scheme is used without game")
+	_, err = run("sign-digest", "-key-id", "rotated", "-game", "dicegame", digest)
+	assert.NotNil(err, "key isn't mapped to game")
+	_, err = run("sign-digest", "-key-id", "missing", digest)
+	assert.NotNil(err)
+	_, err = run("sign-digest", "-key-id", "rotated", "-key-file", keyFile, digest)
+	assert.NotNil(err)
+	pubKey, err = run("keys", "rsa-pubkey", "-key-id", "rotated")
+	assert.Nil(err)
+	parsed, err = utils.ParseRsaPublicKey(pubKey)
+	assert.Nil(err)
+	assert.True(utils.RsaPublicKeysEqual(&rotated.PublicKey, parsed))
+
+	// keystore key source
+	cfg.RSA.Keys = nil
+	cfg.Keys.Source = KeySourceKeystore
+	cfg.Keys.KeystorePath = filepath.Join(dir, "keystore.json")
+	cfg.Keys.KeystorePassphrase = "passphrase"
+	assert.Nil(keystore.Save(cfg.Keys.KeystorePath, "passphrase", map[string]string{"rsa": utils.EncodeRsa(rotated)}))
+	pubKey, err = run("keys", "rsa-pubkey")
+	assert.Nil(err)
+	parsed, err = utils.ParseRsaPublicKey(pubKey)
+	assert.Nil(err)
+	assert.True(utils.RsaPublicKeysEqual(&rotated.PublicKey, parsed))
+}
+
+func TestGetConfig(t *testing.T) {
+	assert := assert.New(t)
+	const devConfig = "configs/config.dev.toml"
+	cfg, err := GetConfig(devConfig)
+	assert.Nil(err)
+	assert.Empty(ValidateConfig(cfg))
+	cfg, err = GetConfig("")
+	assert.Nil(err, "environment only")
+	assert.Equal("file", cfg.Broker.OffsetBackend)
+	_, err = GetConfig("configs/missing.toml")
+	assert.NotNil(err)
+	// missing default config means environment only, explicitly set one fails
+	assert.Equal("", resolveConfigPath("configs/missing.toml", false))
+	assert.Equal("configs/missing.toml", resolveConfigPath("configs/missing.toml", true))
+	assert.Equal(devConfig, resolveConfigPath(devConfig, false))
+
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.toml")
+	assert.Nil(ioutil.WriteFile(path, []byte("[broker]\nurll = \"localhost\"\n[health]\nmaxAge = 1\n"), 0644))
+	_, err = GetConfig(path)
+	assert.EqualError(err, "unknown keys in "+path+": broker.urll, health.maxAge")
+
+	// all problems are reported at once
+	cfg, err = GetConfig(devConfig)
+	assert.Nil(err)
+	cfg.BlockChain.ChainID = "cda75f"
+	cfg.BlockChain.CasinoAccountName = "DaoCasino"
+	cfg.BlockChain.SigniDiceKey = "5KXQ"
+	cfg.Broker.URL = ""
+	cfg.ChainState.RefBlock = "tip"
+	var problems []string
+	for _, err := range ValidateConfig(cfg) {
+		problems = append(problems, err.Error())
+	}
+	assert.Equal([]string{
+		"broker.url is required",
+		`blockChain.chainID "cda75f" isn't a hex encoded 32 bytes hash`,
+		`blockChain.casinoAccountName "DaoCasino" isn't a valid account name`,
+		problems[3], // key parsing error
+		`chainState.refBlock is "tip", expected one of lib, head`,
+	}, problems)
+	assert.True(strings.HasPrefix(problems[3], "blockChain.signiDiceKey is malformed"))
+
+	// zero intervals would make tickers panic and zero retries would push once only
+	cfg, _ = GetConfig(devConfig)
+	cfg.BlockChain.HealthCheckInterval = 0
+	cfg.HTTP.RetryAmount = 0
+	cfg.ChainState.PollInterval = 0
+	cfg.Snapshots.Path, cfg.Snapshots.Interval = "snapshots", 0
+	cfg.Fallback.CursorPath, cfg.Fallback.PollInterval = "cursor", 0
+	cfg.Election.Backend, cfg.Election.LeaseTTL = election.BackendMemory, 0
+	problems = nil
+	for _, err := range ValidateConfig(cfg) {
+		problems = append(problems, err.Error())
+	}
+	assert.Equal([]string{
+		"blockChain.healthCheckInterval is 0, it must be at least 1",
+		"http.retryAmount is 0, it must be at least 1",
+		"chainState.pollInterval is 0, it must be at least 1",
+		"snapshots.interval is 0, it must be at least 1",
+		"fallback.pollInterval is 0, it must be at least 1",
+		"election.leaseTTL is 0, it must be at least 1",
+	}, problems)
+	// intervals of disabled features aren't checked
+	cfg, _ = GetConfig(devConfig)
+	cfg.Snapshots.Path, cfg.Snapshots.Interval = "", 0
+	cfg.Fallback.CursorPath, cfg.Fallback.PollInterval = "", 0
+	cfg.Election.Backend, cfg.Election.LeaseTTL = "", 0
+	assert.Empty(ValidateConfig(cfg))
+
+	// effective config is printed with secrets redacted
+	cfg, _ = GetConfig(devConfig)
+	var out bytes.Buffer
+	assert.Nil(RunCommand(devConfig, []string{"config", "check"}, &out))
+	printed := out.String()
+	assert.Contains(printed, `Token = "<redacted>"`)
+	assert.Contains(printed, `URL = "localhost:8888"`)
+	for _, secret := range []string{cfg.Broker.Token, cfg.BlockChain.DepositKey, cfg.BlockChain.SigniDiceKey,
+		cfg.BlockChain.RSAKey} {
+		assert.NotContains(printed, secret)
+	}
+	cfg.Tracing.Headers = map[string]string{"Authorization": "Bearer token"}
+	redactedCfg, err := RedactConfig(cfg)
+	assert.Nil(err)
+	assert.Equal(redacted, redactedCfg.Tracing.Headers["Authorization"])
+	assert.Equal("Bearer token", cfg.Tracing.Headers["Authorization"], "config isn't changed by redaction")
+	assert.Equal("secretToken", cfg.Broker.Token)
+	assert.NotNil(RunCommand(path, []string{"config", "check"}, ioutil.Discard))
+}
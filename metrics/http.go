@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Middleware counts requests by route template rather than by path, so that path params don't blow up cardinality
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: writer, code: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+		route := "unknown"
+		if current := mux.CurrentRoute(req); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		HTTPRequests.WithLabelValues(route, strconv.Itoa(recorder.code)).Inc()
+	})
+}
@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -9,6 +11,16 @@ import (
 
 const prometheusPrefix = "casino_"
 
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+var latencyBucketsMs = []float64{5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// lastEventAt is unix nano time of the last processed event, process start until any
+var lastEventAt = time.Now().UnixNano()
+
 var (
 	registry   *prometheus.Registry
 	registerer prometheus.Registerer
@@ -26,6 +38,141 @@ var (
 			Help:    "HTTP /sign_transaction query processing time in ms",
 			Buckets: []float64{20, 50, 100, 200, 500},
 		})
+
+	SigniDiceEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "signidice_part_2_events_total",
+			Help: "processed signidice part 2 events by outcome and failure reason",
+		}, []string{"outcome", "reason"})
+
+	SignTransactionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sign_transaction_requests_total",
+			Help: "HTTP /sign_transaction queries by outcome and failure reason",
+		}, []string{"outcome", "reason"})
+
+	PushTransactionRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "push_transaction_retries_total",
+			Help: "push_transaction attempts made after the first one",
+		})
+
+	PushTransactionDuplicates = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "push_transaction_duplicates_total",
+			Help: "push_transaction calls rejected as duplicate trx and assumed as OK",
+		})
+
+	BlockchainCallMs = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "blockchain_call_ms",
+			Help:    "blockchain node RPC call time in ms by method and result",
+			Buckets: latencyBucketsMs,
+		}, []string{"method", "outcome"})
+
+	BrokerSubscribed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "broker_subscribed",
+			Help: "1 if service is subscribed to broker events, 0 otherwise",
+		})
+
+	Leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "leader",
+			Help: "1 if replica holds leader lease and processes events, 0 otherwise",
+		})
+
+	ChainFallback = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chain_fallback",
+			Help: "1 if broker is unavailable and events are polled from chain, 0 otherwise",
+		})
+
+	ChainPollBlockNum = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chain_poll_block_num",
+			Help: "last block read by chain polling fallback",
+		})
+
+	BrokerOffset = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "broker_offset",
+			Help: "broker offset the service will resume from",
+		})
+
+	SecondsSinceLastEvent = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "seconds_since_last_event",
+			Help: "time passed since the last processed signidice event or since start if there were none",
+		}, func() float64 {
+			return time.Since(time.Unix(0, atomic.LoadInt64(&lastEventAt))).Seconds()
+		})
+
+	HTTPRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route and status code",
+		}, []string{"route", "code"})
+
+	PushTransactionErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "push_transaction_errors_total",
+			Help: "failed push_transaction calls by error kind and chain exception name",
+		}, []string{"kind", "reason"})
+
+	RSAKeySignatures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsa_key_signatures_total",
+			Help: "signidice part 2 signatures made by RSA key ID",
+		}, []string{"key_id"})
+
+	RSAKeyDivergence = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsa_key_divergence_total",
+			Help: "signidice part 2 events refused because configured RSA keys don't match game contract key",
+		}, []string{"game"})
+
+	ChainHeadBlockNum = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chain_head_block_num",
+			Help: "head block number known by chain state tracker",
+		})
+
+	ChainLIBNum = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chain_lib_num",
+			Help: "last irreversible block number known by chain state tracker",
+		})
+
+	ChainStateAgeSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chain_state_age_seconds",
+			Help: "time passed since the last successful chain state refresh",
+		})
+
+	NodeLatencyMs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_latency_ms",
+			Help: "blockchain node average response time in ms",
+		}, []string{"node"})
+
+	NodeErrorRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_error_rate",
+			Help: "blockchain node average rate of failed calls",
+		}, []string{"node"})
+
+	NodeHeadBlockLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_head_block_lag",
+			Help: "amount of blocks the node is behind the most advanced node",
+		}, []string{"node"})
+
+	NodeHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_healthy",
+			Help: "1 if blockchain node is considered healthy, 0 otherwise",
+		}, []string{"node"})
 )
 
 func init() {
@@ -34,6 +181,41 @@ func init() {
 	registerer.MustRegister(prometheus.NewGoCollector())
 	registerer.MustRegister(SigniDiceProcessingTimeMs)
 	registerer.MustRegister(SignTransactionProcessingTimeMs)
+	registerer.MustRegister(SigniDiceEvents)
+	registerer.MustRegister(SignTransactionRequests)
+	registerer.MustRegister(PushTransactionRetries)
+	registerer.MustRegister(PushTransactionDuplicates)
+	registerer.MustRegister(BlockchainCallMs)
+	registerer.MustRegister(BrokerSubscribed)
+	registerer.MustRegister(BrokerOffset)
+	registerer.MustRegister(Leader)
+	registerer.MustRegister(ChainFallback)
+	registerer.MustRegister(ChainPollBlockNum)
+	registerer.MustRegister(SecondsSinceLastEvent)
+	registerer.MustRegister(HTTPRequests)
+	registerer.MustRegister(PushTransactionErrors)
+	registerer.MustRegister(RSAKeySignatures)
+	registerer.MustRegister(RSAKeyDivergence)
+	registerer.MustRegister(ChainHeadBlockNum)
+	registerer.MustRegister(ChainLIBNum)
+	registerer.MustRegister(ChainStateAgeSeconds)
+	registerer.MustRegister(NodeLatencyMs)
+	registerer.MustRegister(NodeErrorRate)
+	registerer.MustRegister(NodeHeadBlockLag)
+	registerer.MustRegister(NodeHealthy)
+}
+
+// MarkEventProcessed resets time since last event
+func MarkEventProcessed() {
+	atomic.StoreInt64(&lastEventAt, time.Now().UnixNano())
+}
+
+// Outcome returns outcome label matching failure reason, empty reason means success
+func Outcome(reason string) string {
+	if reason == "" {
+		return OutcomeSuccess
+	}
+	return OutcomeFailure
 }
 
 func GetHandler() http.Handler {
@@ -0,0 +1,362 @@
+package mocks
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eoscanada/eos-go"
+)
+
+const (
+	chainAPIPrefix        = "/v1/chain/"
+	unknownKeyErrorCode   = 3060002
+	defaultTableRowsLimit = 10
+)
+
+type tableKey struct {
+	code, scope, table string
+}
+
+type tableRow struct {
+	key  uint64
+	data json.RawMessage
+}
+
+type injectedError struct {
+	err   eos.APIError
+	times int
+}
+
+// FakeChain is an in-memory stand-in of the node's /v1/chain/* HTTP API.
+// It records pushed transactions, serves table rows and can inject errors and latencies per endpoint.
+type FakeChain struct {
+	sync.Mutex
+	Info     eos.InfoResp
+	Accounts map[eos.AccountName]*eos.AccountResp
+	Balances map[eos.AccountName][]eos.Asset
+
+	pushed    []*eos.SignedTransaction
+	blocks    map[uint32][]*eos.PackedTransaction
+	tables    map[tableKey][]tableRow
+	errors    map[string][]*injectedError
+	latencies map[string]time.Duration
+	calls     map[string]int
+}
+
+func NewFakeChain(chainID eos.Checksum256) *FakeChain {
+	return &FakeChain{
+		Info: eos.InfoResp{
+			ChainID:                  chainID,
+			HeadBlockNum:             1000,
+			LastIrreversibleBlockNum: 990,
+			LastIrreversibleBlockID:  BlockID(990),
+			HeadBlockID:              BlockID(1000),
+		},
+		Accounts:  make(map[eos.AccountName]*eos.AccountResp),
+		Balances:  make(map[eos.AccountName][]eos.Asset),
+		blocks:    make(map[uint32][]*eos.PackedTransaction),
+		tables:    make(map[tableKey][]tableRow),
+		errors:    make(map[string][]*injectedError),
+		latencies: make(map[string]time.Duration),
+		calls:     make(map[string]int),
+	}
+}
+
+// InjectError makes next `times` calls of endpoint (e.g. "push_transaction") fail with err
+func (c *FakeChain) InjectError(endpoint string, err eos.APIError, times int) {
+	c.Lock()
+	defer c.Unlock()
+	c.errors[endpoint] = append(c.errors[endpoint], &injectedError{err, times})
+}
+
+// SetLatency delays every call of endpoint by d
+func (c *FakeChain) SetLatency(endpoint string, d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.latencies[endpoint] = d
+}
+
+// SetTableRow inserts or replaces row with primary key
+func (c *FakeChain) SetTableRow(code, scope, table string, key uint64, row interface{}) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	tk := tableKey{code, scope, table}
+	rows := c.tables[tk]
+	i := sort.Search(len(rows), func(i int) bool { return rows[i].key >= key })
+	if i < len(rows) && rows[i].key == key {
+		rows[i].data = data
+		return nil
+	}
+	rows = append(rows, tableRow{})
+	copy(rows[i+1:], rows[i:])
+	rows[i] = tableRow{key, data}
+	c.tables[tk] = rows
+	return nil
+}
+
+// AddBlockTransaction includes executed transaction into block served by get_block
+func (c *FakeChain) AddBlockTransaction(num uint32, trx *eos.PackedTransaction) {
+	c.Lock()
+	defer c.Unlock()
+	c.blocks[num] = append(c.blocks[num], trx)
+}
+
+// Pushed returns transactions accepted by push_transaction
+func (c *FakeChain) Pushed() []*eos.SignedTransaction {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*eos.SignedTransaction(nil), c.pushed...)
+}
+
+// Calls returns amount of endpoint calls including failed ones
+func (c *FakeChain) Calls(endpoint string) int {
+	c.Lock()
+	defer c.Unlock()
+	return c.calls[endpoint]
+}
+
+func (c *FakeChain) respond(w http.ResponseWriter, code int, payload interface{}) {
+	body, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func (c *FakeChain) unknownKey(w http.ResponseWriter, what string) {
+	apiErr := eos.APIError{Code: http.StatusInternalServerError, Message: "Internal Service Error"}
+	apiErr.ErrorStruct.Code = unknownKeyErrorCode
+	apiErr.ErrorStruct.Name = "unknown_key"
+	apiErr.ErrorStruct.What = what
+	c.respond(w, http.StatusInternalServerError, apiErr)
+}
+
+// takeError returns injected error for endpoint if any, must be called with lock held
+func (c *FakeChain) takeError(endpoint string) *eos.APIError {
+	queue := c.errors[endpoint]
+	if len(queue) == 0 {
+		return nil
+	}
+	injected := queue[0]
+	injected.times--
+	if injected.times <= 0 {
+		c.errors[endpoint] = queue[1:]
+	}
+	return &injected.err
+}
+
+func (c *FakeChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, chainAPIPrefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, chainAPIPrefix)
+	body, _ := ioutil.ReadAll(r.Body)
+
+	c.Lock()
+	c.calls[endpoint]++
+	latency := c.latencies[endpoint]
+	injected := c.takeError(endpoint)
+	c.Unlock()
+
+	time.Sleep(latency)
+	if injected != nil {
+		c.respond(w, injected.Code, injected)
+		return
+	}
+
+	switch endpoint {
+	case "get_info":
+		c.Lock()
+		info := c.Info
+		c.Unlock()
+		c.respond(w, http.StatusOK, info)
+	case "push_transaction":
+		c.pushTransaction(w, body)
+	case "get_table_rows":
+		c.getTableRows(w, body)
+	case "get_block":
+		c.getBlock(w, body)
+	case "get_account":
+		c.getAccount(w, body)
+	case "get_currency_balance":
+		c.getCurrencyBalance(w, body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (c *FakeChain) pushTransaction(w http.ResponseWriter, body []byte) {
+	packed := &eos.PackedTransaction{}
+	if err := json.Unmarshal(body, packed); err != nil {
+		c.respond(w, http.StatusBadRequest, eos.APIError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	signed, err := packed.Unpack()
+	if err != nil {
+		c.respond(w, http.StatusBadRequest, eos.APIError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	id, err := packed.ID()
+	if err != nil {
+		c.respond(w, http.StatusBadRequest, eos.APIError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	c.Lock()
+	c.pushed = append(c.pushed, signed)
+	blockNum := c.Info.HeadBlockNum
+	c.Unlock()
+	c.respond(w, http.StatusAccepted, eos.PushTransactionFullResp{TransactionID: id.String(), BlockNum: blockNum})
+}
+
+func (c *FakeChain) getTableRows(w http.ResponseWriter, body []byte) {
+	var req eos.GetTableRowsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.respond(w, http.StatusBadRequest, eos.APIError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	lowerBound, err := parseBound(req.LowerBound, 0)
+	if err != nil {
+		c.respond(w, http.StatusBadRequest, eos.APIError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	upperBound, err := parseBound(req.UpperBound, math.MaxUint64)
+	if err != nil {
+		c.respond(w, http.StatusBadRequest, eos.APIError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultTableRowsLimit
+	}
+
+	c.Lock()
+	rows := c.tables[tableKey{req.Code, req.Scope, req.Table}]
+	result := []json.RawMessage{}
+	more := false
+	for _, row := range rows {
+		if row.key < lowerBound {
+			continue
+		}
+		if row.key > upperBound {
+			break
+		}
+		if len(result) == limit {
+			more = true
+			break
+		}
+		result = append(result, row.data)
+	}
+	c.Unlock()
+
+	data, _ := json.Marshal(result)
+	c.respond(w, http.StatusOK, eos.GetTableRowsResp{More: more, Rows: data})
+}
+
+// parseBound parses table key given as number or name, empty bound is unset
+func parseBound(bound string, unset uint64) (uint64, error) {
+	if bound == "" {
+		return unset, nil
+	}
+	if key, err := strconv.ParseUint(bound, 10, 64); err == nil {
+		return key, nil
+	}
+	return eos.StringToName(bound)
+}
+
+// BlockID returns fake block ID which encodes block number in the first 4 bytes like the real one
+func BlockID(num uint32) eos.Checksum256 {
+	id := make(eos.Checksum256, 32)
+	binary.BigEndian.PutUint32(id, num)
+	return id
+}
+
+func (c *FakeChain) getBlock(w http.ResponseWriter, body []byte) {
+	var req struct {
+		BlockNumOrID string `json:"block_num_or_id"`
+	}
+	_ = json.Unmarshal(body, &req)
+	num, err := strconv.ParseUint(req.BlockNumOrID, 10, 32)
+	c.Lock()
+	head := c.Info.HeadBlockNum
+	if err != nil || uint32(num) > head {
+		c.Unlock()
+		c.unknownKey(w, "unknown block")
+		return
+	}
+	transactions := []interface{}{}
+	for _, trx := range c.blocks[uint32(num)] {
+		transactions = append(transactions, map[string]interface{}{
+			"status":          "executed",
+			"cpu_usage_us":    100,
+			"net_usage_words": 10,
+			"trx":             trx,
+		})
+	}
+	c.Unlock()
+	// eos.BlockResp can't be marshaled with empty producer signature
+	c.respond(w, http.StatusOK, map[string]interface{}{
+		"id":           BlockID(uint32(num)),
+		"block_num":    num,
+		"previous":     BlockID(uint32(num) - 1),
+		"transactions": transactions,
+	})
+}
+
+func (c *FakeChain) getAccount(w http.ResponseWriter, body []byte) {
+	var req struct {
+		AccountName eos.AccountName `json:"account_name"`
+	}
+	_ = json.Unmarshal(body, &req)
+	c.Lock()
+	account, ok := c.Accounts[req.AccountName]
+	c.Unlock()
+	if !ok {
+		c.unknownKey(w, "unknown key")
+		return
+	}
+	// eos.AccountResp can't be marshaled with zero-valued assets, so only meaningful fields are sent
+	resp := map[string]interface{}{
+		"account_name": account.AccountName,
+		"privileged":   account.Privileged,
+		"ram_quota":    account.RAMQuota,
+		"ram_usage":    account.RAMUsage,
+		"net_weight":   account.NetWeight,
+		"cpu_weight":   account.CPUWeight,
+		"net_limit":    account.NetLimit,
+		"cpu_limit":    account.CPULimit,
+		"permissions":  account.Permissions,
+	}
+	if account.CoreLiquidBalance.Symbol.Symbol != "" {
+		resp["core_liquid_balance"] = account.CoreLiquidBalance
+	}
+	c.respond(w, http.StatusOK, resp)
+}
+
+func (c *FakeChain) getCurrencyBalance(w http.ResponseWriter, body []byte) {
+	var req struct {
+		Account eos.AccountName `json:"account"`
+		Symbol  string          `json:"symbol"`
+	}
+	_ = json.Unmarshal(body, &req)
+	c.Lock()
+	defer c.Unlock()
+	result := []eos.Asset{}
+	for _, asset := range c.Balances[req.Account] {
+		if req.Symbol == "" || asset.Symbol.Symbol == req.Symbol {
+			result = append(result, asset)
+		}
+	}
+	c.respond(w, http.StatusOK, result)
+}
@@ -1,60 +1,72 @@
 package mocks
 
 import (
-	"bytes"
 	"context"
 	"sync"
 
 	broker "github.com/DaoCasino/platform-action-monitor-client"
 )
 
-type EventListenerMock struct{}
+type EventListenerMock struct {
+	m            sync.Mutex
+	subscribed   []uint64 // offsets of subscriptions
+	subscribeErr error
+}
 
 func (e *EventListenerMock) ListenAndServe(ctx context.Context) error {
 	return nil
 }
 
 func (e *EventListenerMock) Subscribe(eventType broker.EventType, offset uint64) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.subscribeErr != nil {
+		return false, e.subscribeErr
+	}
+	e.subscribed = append(e.subscribed, offset)
 	return true, nil
 }
 
-func (e *EventListenerMock) Unsubscribe(eventType broker.EventType) (bool, error) {
-	return true, nil
+// SetSubscribeError makes subscriptions fail with err, e.g. to emulate unavailable broker
+func (e *EventListenerMock) SetSubscribeError(err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.subscribeErr = err
 }
 
-func (e *EventListenerMock) Run(ctx context.Context) {
+// Subscriptions returns offsets of subscriptions made so far
+func (e *EventListenerMock) Subscriptions() []uint64 {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return append([]uint64{}, e.subscribed...)
 }
 
-type SafeBuffer struct {
-	b bytes.Buffer
-	m sync.Mutex
+func (e *EventListenerMock) Unsubscribe(eventType broker.EventType) (bool, error) {
+	return true, nil
 }
 
-func (b *SafeBuffer) Read(p []byte) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Read(p)
+func (e *EventListenerMock) Run(ctx context.Context) {
 }
 
-func (b *SafeBuffer) Write(p []byte) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Write(p)
+// OffsetStoreMock keeps offset in memory
+type OffsetStoreMock struct {
+	offset uint64
+	m      sync.Mutex
 }
 
-func (b *SafeBuffer) String() string {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.String()
+func (s *OffsetStoreMock) Load() (uint64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.offset, nil
 }
 
-func (b *SafeBuffer) Truncate(size int64) error {
-	b.m.Lock()
-	defer b.m.Unlock()
-	b.b.Reset()
+func (s *OffsetStoreMock) Save(offset uint64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.offset = offset
 	return nil
 }
 
-func (b *SafeBuffer) Seek(offset int64, whence int) (ret int64, err error) {
-	return 0, nil
+func (s *OffsetStoreMock) Close() error {
+	return nil
 }
@@ -0,0 +1,132 @@
+package mocks
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+)
+
+const walletAPIPrefix = "/v1/wallet/"
+
+// FakeWallet is an in-memory stand-in of keosd /v1/wallet/* HTTP API used for remote signing
+type FakeWallet struct {
+	sync.Mutex
+	keys  *eos.KeyBag
+	calls map[string]int
+}
+
+func NewFakeWallet(wifs ...string) (*FakeWallet, error) {
+	keys := eos.NewKeyBag()
+	for _, wif := range wifs {
+		if err := keys.Add(wif); err != nil {
+			return nil, err
+		}
+	}
+	return &FakeWallet{keys: keys, calls: make(map[string]int)}, nil
+}
+
+// Calls returns amount of endpoint calls
+func (w *FakeWallet) Calls(endpoint string) int {
+	w.Lock()
+	defer w.Unlock()
+	return w.calls[endpoint]
+}
+
+func (w *FakeWallet) respond(rw http.ResponseWriter, code int, payload interface{}) {
+	body, _ := json.Marshal(payload)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	_, _ = rw.Write(body)
+}
+
+func (w *FakeWallet) fail(rw http.ResponseWriter, message string) {
+	w.respond(rw, http.StatusInternalServerError,
+		eos.APIError{Code: http.StatusInternalServerError, Message: message})
+}
+
+func (w *FakeWallet) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, walletAPIPrefix) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, walletAPIPrefix)
+	body, _ := ioutil.ReadAll(r.Body)
+
+	w.Lock()
+	w.calls[endpoint]++
+	w.Unlock()
+
+	switch endpoint {
+	case "get_public_keys":
+		keys, _ := w.keys.AvailableKeys()
+		result := []string{}
+		for _, key := range keys {
+			result = append(result, key.String())
+		}
+		w.respond(rw, http.StatusCreated, result)
+	case "sign_transaction":
+		w.signTransaction(rw, body)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// signTransaction accepts [trx, [public keys], chain_id] like keosd does
+func (w *FakeWallet) signTransaction(rw http.ResponseWriter, body []byte) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil || len(params) != 3 {
+		w.fail(rw, "invalid params")
+		return
+	}
+	tx := &eos.SignedTransaction{}
+	var textKeys []string
+	var textChainID string
+	if err := json.Unmarshal(params[0], tx); err != nil {
+		w.fail(rw, err.Error())
+		return
+	}
+	if err := json.Unmarshal(params[1], &textKeys); err != nil {
+		w.fail(rw, err.Error())
+		return
+	}
+	if err := json.Unmarshal(params[2], &textChainID); err != nil {
+		w.fail(rw, err.Error())
+		return
+	}
+	chainID, err := hex.DecodeString(textChainID)
+	if err != nil {
+		w.fail(rw, err.Error())
+		return
+	}
+	// keosd receives packed action data as hex string in "data" field
+	for _, action := range tx.Actions {
+		if data, ok := action.Data.(string); ok {
+			if action.HexData, err = hex.DecodeString(data); err != nil {
+				w.fail(rw, err.Error())
+				return
+			}
+			action.Data = nil
+		}
+	}
+	var keys []ecc.PublicKey
+	for _, textKey := range textKeys {
+		key, err := ecc.NewPublicKey(textKey)
+		if err != nil {
+			w.fail(rw, err.Error())
+			return
+		}
+		keys = append(keys, key)
+	}
+	signed, err := w.keys.Sign(tx, chainID, keys...)
+	if err != nil {
+		w.fail(rw, err.Error())
+		return
+	}
+	w.respond(rw, http.StatusCreated, signed)
+}
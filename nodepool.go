@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// weight of the latest observation in exponentially weighted moving averages
+	nodeStatsSmoothing = 0.2
+	// penalty in ms added to node score for every percent of failed calls
+	nodeErrorPenaltyMs = 50
+	// penalty in ms added to node score for every block the node is behind the best one
+	nodeLagPenaltyMs = 100
+	// node is considered unhealthy after that amount of failed calls in a row
+	nodeMaxConsecutiveFails = 3
+	// used if request timeout isn't configured, so a hanging node can't block callers forever
+	defaultNodeRequestTimeout = 5 * time.Second
+)
+
+type NodePoolConfig struct {
+	URLs                []string
+	HealthCheckInterval time.Duration
+	MaxHeadBlockLag     uint32
+	RequestTimeout      time.Duration // per node request, the next node is tried once it's over
+}
+
+// NodeHealth is a snapshot of node statistics
+type NodeHealth struct {
+	URL              string    `json:"url"`
+	LatencyMs        float64   `json:"latency_ms"`
+	ErrorRate        float64   `json:"error_rate"`
+	HeadBlockNum     uint32    `json:"head_block_num"`
+	HeadBlockLag     uint32    `json:"head_block_lag"`
+	ConsecutiveFails int       `json:"consecutive_fails"`
+	Healthy          bool      `json:"healthy"`
+	LastError        string    `json:"last_error,omitempty"`
+	LastCheck        time.Time `json:"last_check"`
+}
+
+type node struct {
+	api *eos.API
+	sync.Mutex
+	latencyMs        float64
+	errorRate        float64
+	headBlockNum     uint32
+	headBlockLag     uint32
+	consecutiveFails int
+	lastError        string
+	lastCheck        time.Time
+}
+
+func (n *node) healthy(maxLag uint32) bool {
+	return n.consecutiveFails < nodeMaxConsecutiveFails && (maxLag == 0 || n.headBlockLag <= maxLag)
+}
+
+func (n *node) score() float64 {
+	return n.latencyMs + n.errorRate*100*nodeErrorPenaltyMs + float64(n.headBlockLag)*nodeLagPenaltyMs
+}
+
+func (n *node) record(elapsed time.Duration, err error) {
+	n.Lock()
+	defer n.Unlock()
+	failed := 0.0
+	if err != nil {
+		failed = 1
+		n.consecutiveFails++
+		n.lastError = err.Error()
+	} else {
+		n.consecutiveFails = 0
+		n.latencyMs = nodeStatsSmoothing*float64(elapsed.Milliseconds()) + (1-nodeStatsSmoothing)*n.latencyMs
+	}
+	n.errorRate = nodeStatsSmoothing*failed + (1-nodeStatsSmoothing)*n.errorRate
+	metrics.NodeLatencyMs.WithLabelValues(n.api.BaseURL).Set(n.latencyMs)
+	metrics.NodeErrorRate.WithLabelValues(n.api.BaseURL).Set(n.errorRate)
+}
+
+// NodePool routes blockchain calls to the healthiest node and fails over to others on node errors
+type NodePool struct {
+	nodes  []*node
+	Signer eos.Signer
+	*NodePoolConfig
+}
+
+func NewNodePool(cfg *NodePoolConfig) *NodePool {
+	pool := &NodePool{NodePoolConfig: cfg}
+	if pool.RequestTimeout <= 0 {
+		pool.RequestTimeout = defaultNodeRequestTimeout
+	}
+	for _, url := range cfg.URLs {
+		pool.nodes = append(pool.nodes, &node{api: eos.New(url)})
+	}
+	return pool
+}
+
+func (p *NodePool) SetSigner(s eos.Signer) {
+	p.Signer = s
+	for _, n := range p.nodes {
+		n.api.SetSigner(s)
+	}
+}
+
+func (p *NodePool) Sign(tx *eos.SignedTransaction, chainID []byte,
+	requiredKeys ...ecc.PublicKey) (*eos.SignedTransaction, error) {
+	if p.Signer == nil {
+		return nil, fmt.Errorf("no signer set")
+	}
+	return p.Signer.Sign(tx, chainID, requiredKeys...)
+}
+
+// ordered returns nodes sorted from the best to the worst, unhealthy nodes go last
+func (p *NodePool) ordered() []*node {
+	type rated struct {
+		node    *node
+		healthy bool
+		score   float64
+	}
+	ratings := make([]rated, len(p.nodes))
+	for i, n := range p.nodes {
+		n.Lock()
+		ratings[i] = rated{n, n.healthy(p.MaxHeadBlockLag), n.score()}
+		n.Unlock()
+	}
+	sort.SliceStable(ratings, func(i, j int) bool {
+		if ratings[i].healthy != ratings[j].healthy {
+			return ratings[i].healthy
+		}
+		return ratings[i].score < ratings[j].score
+	})
+	result := make([]*node, len(ratings))
+	for i := range ratings {
+		result[i] = ratings[i].node
+	}
+	return result
+}
+
+// isNodeError returns true if error is caused by node itself rather than by request content,
+// so the request is worth repeating on another node
+func isNodeError(err error) bool {
+	if err == eos.ErrNotFound {
+		return false
+	}
+	if apiErr, ok := err.(eos.APIError); ok {
+		return apiErr.Code == http.StatusBadGateway ||
+			apiErr.Code == http.StatusServiceUnavailable ||
+			apiErr.Code == http.StatusGatewayTimeout
+	}
+	return true
+}
+
+// contextTransport binds every request to ctx, as eos-go API calls don't accept one
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(req.WithContext(t.ctx))
+}
+
+// withContext returns a copy of node API which requests are cancelled once ctx is done
+func withContext(ctx context.Context, api *eos.API) *eos.API {
+	transport := api.HttpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &eos.API{
+		HttpClient: &http.Client{
+			Transport: &contextTransport{ctx, transport},
+			Timeout:   api.HttpClient.Timeout,
+		},
+		BaseURL:                 api.BaseURL,
+		Signer:                  api.Signer,
+		Debug:                   api.Debug,
+		Compress:                api.Compress,
+		Header:                  api.Header,
+		DefaultMaxCPUUsageMS:    api.DefaultMaxCPUUsageMS,
+		DefaultMaxNetUsageWords: api.DefaultMaxNetUsageWords,
+	}
+}
+
+// nodeContext bounds request to a single node by RequestTimeout,
+// if ctx has a deadline the time left is shared with the rest nodesLeft-1 nodes, so they are tried before it
+func (p *NodePool) nodeContext(ctx context.Context, nodesLeft int) (context.Context, context.CancelFunc) {
+	timeout := p.RequestTimeout
+	if deadline, ok := ctx.Deadline(); ok && nodesLeft > 1 {
+		if share := time.Until(deadline) / time.Duration(nodesLeft); share < timeout {
+			timeout = share
+		}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (p *NodePool) call(method string, f func(api *eos.API) error) error {
+	return p.callContext(context.Background(), method, f)
+}
+
+// callContext makes call with requests bound to ctx and gives up failing over once ctx is done
+func (p *NodePool) callContext(ctx context.Context, method string, f func(api *eos.API) error) error {
+	if len(p.nodes) == 0 {
+		return fmt.Errorf("no blockchain nodes configured")
+	}
+	var err error
+	nodes := p.ordered()
+	for i, n := range nodes {
+		nodeCtx, cancel := p.nodeContext(ctx, len(nodes)-i)
+		start := time.Now()
+		err = f(withContext(nodeCtx, n.api))
+		elapsed := time.Since(start)
+		cancel()
+		outcome := metrics.OutcomeSuccess
+		if err != nil {
+			outcome = metrics.OutcomeFailure
+		}
+		metrics.BlockchainCallMs.WithLabelValues(method, outcome).Observe(elapsed.Seconds() * 1000)
+		if err == nil || !isNodeError(err) {
+			n.record(elapsed, nil)
+			return err
+		}
+		if ctx.Err() == context.Canceled {
+			// node isn't to blame for the caller giving up
+			return err
+		}
+		// node which doesn't answer before deadline of ctx, e.g. retry attempt timeout, is failed too
+		n.record(elapsed, err)
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Warn().Err(err).Str("node", n.api.BaseURL).Str("method", method).Msg("Node failed, trying next one")
+	}
+	return err
+}
+
+func (p *NodePool) GetInfo() (out *eos.InfoResp, err error) {
+	err = p.call("get_info", func(api *eos.API) error {
+		var e error
+		out, e = api.GetInfo()
+		return e
+	})
+	return
+}
+
+// GetInfoContext is GetInfo which is cancelled once ctx is done
+func (p *NodePool) GetInfoContext(ctx context.Context) (out *eos.InfoResp, err error) {
+	err = p.callContext(ctx, "get_info", func(api *eos.API) error {
+		var e error
+		out, e = api.GetInfo()
+		return e
+	})
+	return
+}
+
+func (p *NodePool) PushTransaction(tx *eos.PackedTransaction) (out *eos.PushTransactionFullResp, err error) {
+	err = p.call("push_transaction", func(api *eos.API) error {
+		var e error
+		out, e = api.PushTransaction(tx)
+		return e
+	})
+	return
+}
+
+// PushTransactionContext is PushTransaction which is cancelled once ctx is done
+func (p *NodePool) PushTransactionContext(ctx context.Context,
+	tx *eos.PackedTransaction) (out *eos.PushTransactionFullResp, err error) {
+	err = p.callContext(ctx, "push_transaction", func(api *eos.API) error {
+		var e error
+		out, e = api.PushTransaction(tx)
+		return e
+	})
+	return
+}
+
+func (p *NodePool) GetTableRows(params eos.GetTableRowsRequest) (out *eos.GetTableRowsResp, err error) {
+	err = p.call("get_table_rows", func(api *eos.API) error {
+		var e error
+		out, e = api.GetTableRows(params)
+		return e
+	})
+	return
+}
+
+func (p *NodePool) GetBlockByNum(num uint32) (out *eos.BlockResp, err error) {
+	err = p.call("get_block", func(api *eos.API) error {
+		var e error
+		out, e = api.GetBlockByNum(num)
+		return e
+	})
+	return
+}
+
+func (p *NodePool) GetAccount(name eos.AccountName) (out *eos.AccountResp, err error) {
+	err = p.call("get_account", func(api *eos.API) error {
+		var e error
+		out, e = api.GetAccount(name)
+		return e
+	})
+	return
+}
+
+func (p *NodePool) GetCurrencyBalance(account eos.AccountName, symbol string,
+	code eos.AccountName) (out []eos.Asset, err error) {
+	err = p.call("get_currency_balance", func(api *eos.API) error {
+		var e error
+		out, e = api.GetCurrencyBalance(account, symbol, code)
+		return e
+	})
+	return
+}
+
+// CheckHealth polls every node and updates its head block lag relative to the most advanced node
+func (p *NodePool) CheckHealth() {
+	heads := make([]uint32, len(p.nodes))
+	var wg sync.WaitGroup
+	for i, n := range p.nodes {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			ctx, cancel := p.nodeContext(context.Background(), 1)
+			defer cancel()
+			start := time.Now()
+			info, err := withContext(ctx, n.api).GetInfo()
+			n.record(time.Since(start), err)
+			n.Lock()
+			n.lastCheck = time.Now()
+			if err == nil {
+				n.headBlockNum = info.HeadBlockNum
+			}
+			heads[i] = n.headBlockNum
+			n.Unlock()
+		}(i, n)
+	}
+	wg.Wait()
+
+	var best uint32
+	for _, head := range heads {
+		if head > best {
+			best = head
+		}
+	}
+	for _, n := range p.nodes {
+		n.Lock()
+		n.headBlockLag = best - n.headBlockNum
+		healthy := 0.0
+		if n.healthy(p.MaxHeadBlockLag) {
+			healthy = 1
+		}
+		metrics.NodeHeadBlockLag.WithLabelValues(n.api.BaseURL).Set(float64(n.headBlockLag))
+		metrics.NodeHealthy.WithLabelValues(n.api.BaseURL).Set(healthy)
+		n.Unlock()
+	}
+}
+
+func (p *NodePool) RunHealthChecker(ctx context.Context) {
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+	p.CheckHealth()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.CheckHealth()
+		}
+	}
+}
+
+func (p *NodePool) Health() []NodeHealth {
+	result := make([]NodeHealth, len(p.nodes))
+	for i, n := range p.nodes {
+		n.Lock()
+		result[i] = NodeHealth{
+			URL:              n.api.BaseURL,
+			LatencyMs:        n.latencyMs,
+			ErrorRate:        n.errorRate,
+			HeadBlockNum:     n.headBlockNum,
+			HeadBlockLag:     n.headBlockLag,
+			ConsecutiveFails: n.consecutiveFails,
+			Healthy:          n.healthy(p.MaxHeadBlockLag),
+			LastError:        n.lastError,
+			LastCheck:        n.lastCheck,
+		}
+		n.Unlock()
+	}
+	return result
+}
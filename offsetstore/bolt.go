@@ -0,0 +1,57 @@
+package offsetstore
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketName = []byte("offsets")
+	offsetKey  = []byte("offset")
+)
+
+// BoltStore keeps offset record in embedded bolt database, which is locked by a single process
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens database at path, fails if it's held by another process for longer than a second
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Load() (offset uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		record := bucket.Get(offsetKey)
+		if record == nil {
+			return nil
+		}
+		offset, err = decodeRecord(record)
+		return err
+	})
+	return
+}
+
+// Save commits offset, bolt syncs database file on every commit
+func (s *BoltStore) Save(offset uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketName)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(offsetKey, encodeRecord(offset))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
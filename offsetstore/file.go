@@ -0,0 +1,90 @@
+package offsetstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// FileStore keeps offset record in a file which is replaced atomically on every Save
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load() (uint64, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		// initial start
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if offset, ok := parseLegacy(data); ok {
+		return offset, nil
+	}
+	return decodeRecord(data)
+}
+
+// parseLegacy parses decimal offset written before records had version and checksum,
+// surrounding whitespace is ignored as such files were often written with echo or by hand
+func parseLegacy(data []byte) (uint64, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0, false
+	}
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	offset, err := strconv.ParseUint(string(data), 10, 64)
+	return offset, err == nil
+}
+
+func (s *FileStore) Save(offset uint64) error {
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(encodeRecord(offset)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+// syncDir makes rename durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %s", dir, err.Error())
+	}
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
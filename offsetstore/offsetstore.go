@@ -0,0 +1,64 @@
+// Package offsetstore durably keeps broker topic offset, so that restart neither loses nor replays events
+package offsetstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	BackendFile = "file"
+	BackendBolt = "bolt"
+)
+
+// version of the stored record format
+const version = 1
+
+// ErrCorrupted is returned by Load if stored offset can't be trusted
+var ErrCorrupted = errors.New("stored offset is corrupted")
+
+// OffsetStore keeps the offset broker should resume from
+type OffsetStore interface {
+	// Load returns stored offset, 0 if nothing is stored yet
+	Load() (uint64, error)
+	// Save stores offset, it survives crash once Save returns
+	Save(offset uint64) error
+	Close() error
+}
+
+// Open opens store of the backend at path
+func Open(backend, path string) (OffsetStore, error) {
+	switch backend {
+	case BackendFile, "":
+		return NewFileStore(path), nil
+	case BackendBolt:
+		return NewBoltStore(path)
+	}
+	return nil, fmt.Errorf("unknown offset backend %q", backend)
+}
+
+// record is version, offset and CRC32 of both, all big endian
+const recordSize = 1 + 8 + 4
+
+func encodeRecord(offset uint64) []byte {
+	record := make([]byte, recordSize)
+	record[0] = version
+	binary.BigEndian.PutUint64(record[1:9], offset)
+	binary.BigEndian.PutUint32(record[9:], crc32.ChecksumIEEE(record[:9]))
+	return record
+}
+
+func decodeRecord(record []byte) (uint64, error) {
+	if len(record) != recordSize {
+		return 0, fmt.Errorf("%w: record size is %d, expected %d", ErrCorrupted, len(record), recordSize)
+	}
+	if record[0] != version {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrCorrupted, record[0])
+	}
+	if crc32.ChecksumIEEE(record[:9]) != binary.BigEndian.Uint32(record[9:]) {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+	return binary.BigEndian.Uint64(record[1:9]), nil
+}
@@ -0,0 +1,96 @@
+package offsetstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "offsetstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func testStore(t *testing.T, store OffsetStore) {
+	assert := assert.New(t)
+	offset, err := store.Load()
+	assert.Nil(err)
+	assert.Equal(uint64(0), offset)
+
+	for _, expected := range []uint64{1, 1 << 40, 7} {
+		assert.Nil(store.Save(expected))
+		offset, err = store.Load()
+		assert.Nil(err)
+		assert.Equal(expected, offset)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "offset")
+
+	store, err := Open(BackendFile, path)
+	assert.Nil(err)
+	testStore(t, store)
+	_, err = os.Stat(path + ".tmp")
+	assert.True(os.IsNotExist(err))
+
+	// offset written by older versions
+	for _, legacy := range []string{"42", "42\n", " 42\r\n"} {
+		assert.Nil(ioutil.WriteFile(path, []byte(legacy), 0644))
+		offset, err := store.Load()
+		assert.Nil(err, "%q", legacy)
+		assert.Equal(uint64(42), offset, "%q", legacy)
+	}
+
+	// torn and damaged writes
+	assert.Nil(store.Save(42))
+	record, _ := ioutil.ReadFile(path)
+	damaged := append([]byte{}, record...)
+	damaged[5] ^= 1
+	for _, data := range [][]byte{{}, record[:5], damaged, append([]byte{2}, record[1:]...)} {
+		assert.Nil(ioutil.WriteFile(path, data, 0644))
+		_, err := store.Load()
+		assert.True(errors.Is(err, ErrCorrupted), "%v", data)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "offset.db")
+
+	store, err := Open(BackendBolt, path)
+	assert.Nil(err)
+	testStore(t, store)
+	assert.Nil(store.Close())
+
+	// offset survives reopening
+	store, err = Open(BackendBolt, path)
+	assert.Nil(err)
+	offset, err := store.Load()
+	assert.Nil(err)
+	assert.Equal(uint64(7), offset)
+
+	// damaged record
+	assert.Nil(store.(*BoltStore).db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put(offsetKey, []byte{version, 0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 0})
+	}))
+	_, err = store.Load()
+	assert.True(errors.Is(err, ErrCorrupted))
+	assert.Nil(store.Close())
+
+	_, err = Open("etcd", path)
+	assert.NotNil(err)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/metrics"
+	"github.com/DaoCasino/casino-backend/utils"
+	"github.com/eoscanada/eos-go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRSAKeyID is ID of RSA key set by key source
+const DefaultRSAKeyID = "default"
+
+type RSAKeysConfig struct {
+	PubKeyTable    string // contract table holding RSA public key, a single row is expected
+	PubKeyField    string
+	PubKeyCacheTTL time.Duration
+	Schemes        map[eos.AccountName]utils.RsaScheme // games not listed use utils.DefaultRsaScheme
+}
+
+// rsaScheme returns signing scheme expected by game contract
+func (c *RSAKeysConfig) rsaScheme(game eos.AccountName) utils.RsaScheme {
+	if scheme, ok := c.Schemes[game]; ok {
+		return scheme
+	}
+	return utils.DefaultRsaScheme
+}
+
+// RSAKey is a signidice key with rotation schedule
+type RSAKey struct {
+	ID         string
+	Key        *rsa.PrivateKey
+	ActivateAt time.Time         // zero means always active since
+	RetireAt   time.Time         // zero means never retired
+	Games      []eos.AccountName // empty means any game
+}
+
+// ActiveAt tells if key is allowed to sign at t according to schedule
+func (k *RSAKey) ActiveAt(t time.Time) bool {
+	if !k.ActivateAt.IsZero() && t.Before(k.ActivateAt) {
+		return false
+	}
+	if !k.RetireAt.IsZero() && !t.Before(k.RetireAt) {
+		return false
+	}
+	return true
+}
+
+// ServesGame tells if key is mapped to game contract
+func (k *RSAKey) ServesGame(game eos.AccountName) bool {
+	if len(k.Games) == 0 {
+		return true
+	}
+	for _, g := range k.Games {
+		if g == game {
+			return true
+		}
+	}
+	return false
+}
+
+// RSAKeyring holds RSA keys by ID ordered by activation time, newest first
+type RSAKeyring struct {
+	keys []*RSAKey
+}
+
+func NewRSAKeyring() *RSAKeyring {
+	return &RSAKeyring{}
+}
+
+func (r *RSAKeyring) Add(key *RSAKey) error {
+	if key.Key == nil {
+		return fmt.Errorf("RSA key %s is empty", key.ID)
+	}
+	if _, ok := r.Get(key.ID); ok {
+		return fmt.Errorf("RSA key %s is already set", key.ID)
+	}
+	if !key.RetireAt.IsZero() && !key.ActivateAt.Before(key.RetireAt) {
+		return fmt.Errorf("RSA key %s is retired before activation", key.ID)
+	}
+	r.keys = append(r.keys, key)
+	sort.SliceStable(r.keys, func(i, j int) bool {
+		return r.keys[i].ActivateAt.After(r.keys[j].ActivateAt)
+	})
+	return nil
+}
+
+func (r *RSAKeyring) Get(id string) (*RSAKey, bool) {
+	for _, key := range r.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (r *RSAKeyring) Keys() []*RSAKey {
+	return r.keys
+}
+
+// Candidates returns keys allowed to sign for game at t, newest first
+func (r *RSAKeyring) Candidates(game eos.AccountName, t time.Time) []*RSAKey {
+	var out []*RSAKey
+	for _, key := range r.keys {
+		if key.ActiveAt(t) && key.ServesGame(game) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// Select returns active key of game matching public key expected by contract,
+// if expected key is unknown the newest active key is returned
+func (r *RSAKeyring) Select(game eos.AccountName, expected *rsa.PublicKey, t time.Time) (*RSAKey, error) {
+	candidates := r.Candidates(game, t)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no active RSA key for %s", game)
+	}
+	if expected == nil {
+		return candidates[0], nil
+	}
+	for _, key := range candidates {
+		if utils.RsaPublicKeysEqual(&key.Key.PublicKey, expected) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no active RSA key matches one registered in %s", game)
+}
+
+type cachedRSAPubKey struct {
+	key       *rsa.PublicKey
+	encoded   string // as stored in contract
+	fetchedAt time.Time
+}
+
+// RSAPubKeyCache caches RSA public keys registered in contracts
+type RSAPubKeyCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	fetch   func(contract eos.AccountName) (string, error)
+	entries map[eos.AccountName]cachedRSAPubKey
+}
+
+func NewRSAPubKeyCache(ttl time.Duration,
+	fetch func(contract eos.AccountName) (string, error)) *RSAPubKeyCache {
+	return &RSAPubKeyCache{ttl: ttl, fetch: fetch, entries: make(map[eos.AccountName]cachedRSAPubKey)}
+}
+
+// Get returns cached key or fetches it if cached one is expired
+func (c *RSAPubKeyCache) Get(contract eos.AccountName) (*rsa.PublicKey, error) {
+	entry, err := c.get(contract)
+	if err != nil {
+		return nil, err
+	}
+	return entry.key, nil
+}
+
+// GetEncoded returns key in the form it's stored in contract
+func (c *RSAPubKeyCache) GetEncoded(contract eos.AccountName) (string, error) {
+	entry, err := c.get(contract)
+	if err != nil {
+		return "", err
+	}
+	return entry.encoded, nil
+}
+
+// get returns expired entry if key can't be refetched
+func (c *RSAPubKeyCache) get(contract eos.AccountName) (cachedRSAPubKey, error) {
+	c.Lock()
+	entry, ok := c.entries[contract]
+	c.Unlock()
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return entry, nil
+	}
+	encoded, err := c.fetch(contract)
+	var key *rsa.PublicKey
+	if err == nil {
+		key, err = utils.ParseRsaPublicKey(encoded)
+	}
+	if err != nil {
+		if ok {
+			log.Warn().Err(err).Str("contract", string(contract)).
+				Msg("Failed to refetch RSA public key, using cached one")
+			return entry, nil
+		}
+		return cachedRSAPubKey{}, err
+	}
+	entry = cachedRSAPubKey{key, encoded, time.Now()}
+	c.Lock()
+	c.entries[contract] = entry
+	c.Unlock()
+	return entry, nil
+}
+
+// Invalidate forces refetch of contract key, e.g. after the contract rejected signature
+func (c *RSAPubKeyCache) Invalidate(contract eos.AccountName) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, contract)
+}
+
+// getContractRSAPubKey reads RSA public key registered in contract
+func (app *App) getContractRSAPubKey(contract eos.AccountName) (*rsa.PublicKey, error) {
+	encoded, err := app.getContractEncodedRSAPubKey(contract)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParseRsaPublicKey(encoded)
+}
+
+// getContractEncodedRSAPubKey reads RSA public key in the form it's stored in contract
+func (app *App) getContractEncodedRSAPubKey(contract eos.AccountName) (string, error) {
+	resp, err := app.bcAPI.GetTableRows(eos.GetTableRowsRequest{
+		Code:  string(contract),
+		Scope: string(contract),
+		Table: app.RSAKeys.PubKeyTable,
+		Limit: 1,
+		JSON:  true,
+	})
+	if err != nil {
+		return "", err
+	}
+	var rows []map[string]json.RawMessage
+	if err := resp.JSONToStructs(&rows); err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("table %s of %s is empty", app.RSAKeys.PubKeyTable, contract)
+	}
+	var encoded string
+	if err := json.Unmarshal(rows[0][app.RSAKeys.PubKeyField], &encoded); err != nil {
+		return "", fmt.Errorf("field %s isn't found in table %s of %s",
+			app.RSAKeys.PubKeyField, app.RSAKeys.PubKeyTable, contract)
+	}
+	return encoded, nil
+}
+
+// DigestSignature is RSA signature of digest verified against key of game contract
+type DigestSignature struct {
+	Signature   []byte
+	Scheme      utils.RsaScheme
+	Key         *RSAKey
+	ContractKey string // RSA public key as stored in contract
+}
+
+// Encoded returns signature in the form pushed to contract
+func (s *DigestSignature) Encoded() string {
+	return s.Scheme.Encode(s.Signature)
+}
+
+// signDigest signs digest with key matching one the game contract currently expects
+// and verifies the signature before it's pushed, so that key divergence never reaches the chain
+func (app *App) signDigest(logger *zerolog.Logger, game eos.AccountName, digest []byte) (*DigestSignature, error) {
+	expected, err := app.RSAPubKeys.get(game)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get RSA public key of %s: %s", game, err.Error())
+	}
+	rsaKey, err := app.BlockChain.RSAKeyring.Select(game, expected.key, time.Now())
+	if err != nil {
+		app.reportRSAKeyDivergence(logger, game, err)
+		return nil, err
+	}
+	scheme := app.RSAKeys.rsaScheme(game)
+	signature, err := scheme.Sign(digest, rsaKey.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := scheme.Verify(digest, signature, expected.key); err != nil {
+		err = fmt.Errorf("signature made by RSA key %s doesn't verify against key of %s: %s",
+			rsaKey.ID, game, err.Error())
+		app.reportRSAKeyDivergence(logger, game, err)
+		return nil, err
+	}
+	logger.Debug().Str("key_id", rsaKey.ID).Str("scheme", scheme.String()).Msg("Signed digest")
+	metrics.RSAKeySignatures.WithLabelValues(rsaKey.ID).Inc()
+	return &DigestSignature{signature, scheme, rsaKey, expected.encoded}, nil
+}
+
+func (app *App) reportRSAKeyDivergence(logger *zerolog.Logger, game eos.AccountName, err error) {
+	metrics.RSAKeyDivergence.WithLabelValues(string(game)).Inc()
+	logger.Error().Bool("alert", true).Str("game", string(game)).Err(err).
+		Msg("Configured RSA keys diverge from chain")
+}
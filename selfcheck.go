@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SelfCheckStrict refuses to start if any check fails
+	SelfCheckStrict = "strict"
+	// SelfCheckDegraded starts anyway and reports failed checks
+	SelfCheckDegraded = "degraded"
+	// SelfCheckOff skips checks
+	SelfCheckOff = "off"
+)
+
+type SelfCheckConfig struct {
+	Mode              string
+	DepositPermission eos.PermissionName
+}
+
+type SelfCheckResult struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Details string `json:"details"`
+}
+
+type SelfCheckReport []SelfCheckResult
+
+func (r SelfCheckReport) OK() bool {
+	for _, result := range r {
+		if !result.OK {
+			return false
+		}
+	}
+	return true
+}
+
+func (r SelfCheckReport) String() string {
+	var lines []string
+	for _, result := range r {
+		status := "OK"
+		if !result.OK {
+			status = "FAIL"
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", status, result.Name, result.Details))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func checkResult(name string, err error, okDetails string) SelfCheckResult {
+	if err != nil {
+		return SelfCheckResult{Name: name, OK: false, Details: err.Error()}
+	}
+	return SelfCheckResult{Name: name, OK: true, Details: okDetails}
+}
+
+func (app *App) checkChainID() error {
+	info, err := app.bcAPI.GetInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get node info: %s", err.Error())
+	}
+	if !bytes.Equal(info.ChainID, app.BlockChain.ChainID) {
+		return fmt.Errorf("node chain_id %s doesn't match configured %s",
+			info.ChainID.String(), app.BlockChain.ChainID.String())
+	}
+	return nil
+}
+
+func (app *App) checkKeyPermission(account eos.AccountName, permission eos.PermissionName,
+	key ecc.PublicKey) error {
+	resp, err := app.bcAPI.GetAccount(account)
+	if err != nil {
+		return fmt.Errorf("failed to get account %s: %s", account, err.Error())
+	}
+	for _, perm := range resp.Permissions {
+		if perm.PermName != string(permission) {
+			continue
+		}
+		for _, keyWeight := range perm.RequiredAuth.Keys {
+			if keyWeight.PublicKey.String() == key.String() {
+				return nil
+			}
+		}
+		return fmt.Errorf("key %s isn't found in %s@%s", key.String(), account, permission)
+	}
+	return fmt.Errorf("permission %s@%s doesn't exist", account, permission)
+}
+
+func (app *App) checkContractRSAPubKey(contract eos.AccountName) error {
+	key, err := app.getContractRSAPubKey(contract)
+	if err != nil {
+		return fmt.Errorf("failed to get RSA public key: %s", err.Error())
+	}
+	rsaKey, err := app.BlockChain.RSAKeyring.Select(contract, key, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("key_id", rsaKey.ID).Str("contract", string(contract)).
+		Msg("RSA key matches one registered in contract")
+	return nil
+}
+
+func (app *App) checkAccountExists(account eos.AccountName) error {
+	if _, err := app.bcAPI.GetAccount(account); err != nil {
+		return fmt.Errorf("failed to get account %s: %s", account, err.Error())
+	}
+	return nil
+}
+
+// CheckConfiguration verifies that configured keys, permissions and chain identity match the chain
+func (app *App) CheckConfiguration() SelfCheckReport {
+	bc := app.BlockChain
+	report := SelfCheckReport{
+		checkResult("chain_id", app.checkChainID(), "node chain_id matches config"),
+		checkResult("signidice_key",
+			app.checkKeyPermission(bc.SignerAccountName, eos.PN("active"), bc.EosPubKeys.SigniDice),
+			fmt.Sprintf("signidice key is present on %s@active", bc.SignerAccountName)),
+		checkResult("deposit_key",
+			app.checkKeyPermission(bc.CasinoAccountName, app.SelfCheck.DepositPermission, bc.EosPubKeys.Deposit),
+			fmt.Sprintf("deposit key is present on %s@%s", bc.CasinoAccountName, app.SelfCheck.DepositPermission)),
+		checkResult("casino_rsa_key", app.checkContractRSAPubKey(bc.CasinoAccountName),
+			fmt.Sprintf("active RSA key matches one registered in %s", bc.CasinoAccountName)),
+	}
+	for _, game := range bc.GameContracts {
+		report = append(report, checkResult("game_rsa_key:"+string(game), app.checkContractRSAPubKey(game),
+			fmt.Sprintf("active RSA key matches one registered in %s", game)))
+	}
+	report = append(report, checkResult("platform_account", app.checkAccountExists(bc.PlatformAccountName),
+		fmt.Sprintf("platform account %s exists", bc.PlatformAccountName)))
+	return report
+}
+
+// RunSelfCheck runs self check according to configured mode,
+// returns error only if strict mode is set and some check failed
+func (app *App) RunSelfCheck() error {
+	if app.SelfCheck.Mode == SelfCheckOff {
+		return nil
+	}
+	app.SelfCheckReport = app.CheckConfiguration()
+	if app.SelfCheckReport.OK() {
+		log.Info().Str("report", app.SelfCheckReport.String()).Msg("Self check passed")
+		return nil
+	}
+	if app.SelfCheck.Mode == SelfCheckStrict {
+		return fmt.Errorf("self check failed:\n%s", app.SelfCheckReport.String())
+	}
+	log.Warn().Str("report", app.SelfCheckReport.String()).Msg("Self check failed, starting degraded")
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eoscanada/eos-go"
+	"github.com/rs/zerolog/log"
+)
+
+const snapshotFileExt = ".json"
+
+type SnapshotsConfig struct {
+	Path      string
+	Interval  time.Duration
+	Retention time.Duration // snapshots older than retention are removed, 0 means keep forever
+	MaxAmount int           // max amount of stored snapshots, 0 means unlimited
+}
+
+// BonusSnapshot is a copy of casino's bonus tables at some moment
+type BonusSnapshot struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Stats     []PlayerStats   `json:"stats"`
+	Balances  []PlayerBalance `json:"balances"`
+}
+
+type PlayerStatsDelta struct {
+	Player          string    `json:"player,omitempty"`
+	SessionsCreated int64     `json:"sessions_created"`
+	VolumeReal      eos.Asset `json:"volume_real"`
+	VolumeBonus     eos.Asset `json:"volume_bonus"`
+	ProfitReal      eos.Asset `json:"profit_real"`
+	ProfitBonus     eos.Asset `json:"profit_bonus"`
+	Balance         eos.Asset `json:"balance"`
+}
+
+type BonusDeltas struct {
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Players []PlayerStatsDelta `json:"players"`
+	Total   PlayerStatsDelta   `json:"total"`
+}
+
+// SnapshotStorage keeps every snapshot as a separate json file named by its unix nano timestamp
+type SnapshotStorage struct {
+	dir string
+}
+
+func NewSnapshotStorage(dir string) (*SnapshotStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &SnapshotStorage{dir: dir}, nil
+}
+
+func (s *SnapshotStorage) fileName(ts time.Time) string {
+	return filepath.Join(s.dir, strconv.FormatInt(ts.UnixNano(), 10)+snapshotFileExt)
+}
+
+func (s *SnapshotStorage) Save(snapshot *BonusSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	// write to temp file first to never expose partially written snapshot
+	tmpName := s.fileName(snapshot.Timestamp) + ".tmp"
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, s.fileName(snapshot.Timestamp))
+}
+
+func (s *SnapshotStorage) Load(ts time.Time) (*BonusSnapshot, error) {
+	data, err := ioutil.ReadFile(s.fileName(ts))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &BonusSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// List returns timestamps of stored snapshots in ascending order
+func (s *SnapshotStorage) List() ([]time.Time, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var stamps []time.Time
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), snapshotFileExt) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), snapshotFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		stamps = append(stamps, time.Unix(0, nanos).UTC())
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i].Before(stamps[j]) })
+	return stamps, nil
+}
+
+func (s *SnapshotStorage) Remove(ts time.Time) error {
+	return os.Remove(s.fileName(ts))
+}
+
+// Prune removes snapshots violating retention policies
+func (s *SnapshotStorage) Prune(now time.Time, retention time.Duration, maxAmount int) error {
+	stamps, err := s.List()
+	if err != nil {
+		return err
+	}
+	for i, ts := range stamps {
+		expired := retention > 0 && now.Sub(ts) > retention
+		excess := maxAmount > 0 && len(stamps)-i > maxAmount
+		if !expired && !excess {
+			break
+		}
+		log.Debug().Time("snapshot", ts).Msg("Removing bonus snapshot")
+		if err := s.Remove(ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Closest returns the latest snapshot taken not after ts,
+// or the earliest one if every snapshot is newer than ts
+func (s *SnapshotStorage) Closest(ts time.Time) (*BonusSnapshot, error) {
+	stamps, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(stamps) == 0 {
+		return nil, fmt.Errorf("no snapshots available")
+	}
+	found := stamps[0]
+	for _, stamp := range stamps {
+		if stamp.After(ts) {
+			break
+		}
+		found = stamp
+	}
+	return s.Load(found)
+}
+
+func (app *App) takeBonusSnapshot() (*BonusSnapshot, error) {
+	stats, err := app.getAllBonusPlayersStats()
+	if err != nil {
+		return nil, err
+	}
+	balances, err := app.getAllBonusPlayersBalance()
+	if err != nil {
+		return nil, err
+	}
+	return &BonusSnapshot{Timestamp: time.Now().UTC(), Stats: stats, Balances: balances}, nil
+}
+
+func (app *App) makeSnapshot() {
+	snapshot, err := app.takeBonusSnapshot()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to take bonus snapshot")
+		return
+	}
+	if err := app.SnapshotStorage.Save(snapshot); err != nil {
+		log.Error().Err(err).Msg("Failed to save bonus snapshot")
+		return
+	}
+	log.Debug().Int("players", len(snapshot.Stats)).Msg("Bonus snapshot saved")
+	if err := app.SnapshotStorage.Prune(time.Now(), app.Snapshots.Retention, app.Snapshots.MaxAmount); err != nil {
+		log.Error().Err(err).Msg("Failed to prune bonus snapshots")
+	}
+}
+
+func (app *App) RunSnapshotScheduler(ctx context.Context) {
+	ticker := time.NewTicker(app.Snapshots.Interval)
+	defer ticker.Stop()
+	app.makeSnapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.makeSnapshot()
+		}
+	}
+}
+
+// subAsset is an eos.Asset.Sub which tolerates missing (zero-valued) operands
+func subAsset(a, b eos.Asset) eos.Asset {
+	if b.Symbol.Symbol == "" {
+		return a
+	}
+	if a.Symbol.Symbol == "" {
+		return eos.Asset{Amount: -b.Amount, Symbol: b.Symbol}
+	}
+	return a.Sub(b)
+}
+
+// addAsset is an eos.Asset.Add which tolerates missing (zero-valued) operands
+func addAsset(a, b eos.Asset) eos.Asset {
+	if b.Symbol.Symbol == "" {
+		return a
+	}
+	if a.Symbol.Symbol == "" {
+		return b
+	}
+	return a.Add(b)
+}
+
+func snapshotIndex(snapshot *BonusSnapshot) (map[string]PlayerStats, map[string]PlayerBalance) {
+	stats := make(map[string]PlayerStats, len(snapshot.Stats))
+	for _, s := range snapshot.Stats {
+		stats[s.Player] = s
+	}
+	balances := make(map[string]PlayerBalance, len(snapshot.Balances))
+	for _, b := range snapshot.Balances {
+		balances[b.Player] = b
+	}
+	return stats, balances
+}
+
+// CalcBonusDeltas returns per player and global changes between two snapshots
+func CalcBonusDeltas(from, to *BonusSnapshot) *BonusDeltas {
+	fromStats, fromBalances := snapshotIndex(from)
+	toStats, toBalances := snapshotIndex(to)
+
+	players := make(map[string]struct{})
+	for player := range fromStats {
+		players[player] = struct{}{}
+	}
+	for player := range toStats {
+		players[player] = struct{}{}
+	}
+	for player := range toBalances {
+		players[player] = struct{}{}
+	}
+	for player := range fromBalances {
+		players[player] = struct{}{}
+	}
+	names := make([]string, 0, len(players))
+	for player := range players {
+		names = append(names, player)
+	}
+	sort.Strings(names)
+
+	result := &BonusDeltas{From: from.Timestamp, To: to.Timestamp, Players: []PlayerStatsDelta{}}
+	for _, player := range names {
+		before, after := fromStats[player], toStats[player]
+		delta := PlayerStatsDelta{
+			Player:          player,
+			SessionsCreated: int64(after.SessionsCreated) - int64(before.SessionsCreated),
+			VolumeReal:      subAsset(after.VolumeReal, before.VolumeReal),
+			VolumeBonus:     subAsset(after.VolumeBonus, before.VolumeBonus),
+			ProfitReal:      subAsset(after.ProfitReal, before.ProfitReal),
+			ProfitBonus:     subAsset(after.ProfitBonus, before.ProfitBonus),
+			Balance:         subAsset(toBalances[player].Balance, fromBalances[player].Balance),
+		}
+		result.Players = append(result.Players, delta)
+
+		result.Total.SessionsCreated += delta.SessionsCreated
+		result.Total.VolumeReal = addAsset(result.Total.VolumeReal, delta.VolumeReal)
+		result.Total.VolumeBonus = addAsset(result.Total.VolumeBonus, delta.VolumeBonus)
+		result.Total.ProfitReal = addAsset(result.Total.ProfitReal, delta.ProfitReal)
+		result.Total.ProfitBonus = addAsset(result.Total.ProfitBonus, delta.ProfitBonus)
+		result.Total.Balance = addAsset(result.Total.Balance, delta.Balance)
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DaoCasino/casino-backend/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	TracingExporterOTLP = "otlp"
+	TracingExporterFile = "file"
+)
+
+type TracingConfig struct {
+	Exporter      string // tracing is disabled if empty
+	Endpoint      string // OTLP/HTTP collector URL
+	Headers       map[string]string
+	FilePath      string
+	ServiceName   string
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+}
+
+// NewTracer makes provider exporting spans as configured, returns nil if tracing is disabled
+func NewTracer(cfg *TracingConfig) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(cfg.Exporter) {
+	case "":
+		return nil, nil
+	case TracingExporterOTLP:
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("OTLP endpoint isn't set")
+		}
+		exporter, err = tracing.NewOTLPExporter(cfg.Endpoint, cfg.Headers, cfg.Timeout)
+	case TracingExporterFile:
+		exporter, err = tracing.NewFileExporter(cfg.FilePath)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Warn().Err(err).Msg("Tracing error")
+	}))
+	return tracing.NewProvider(cfg.ServiceName, exporter, cfg.BatchSize, cfg.FlushInterval), nil
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const otlpTracesPath = "/v1/traces"
+
+// NewOTLPExporter makes exporter sending spans to OTLP/HTTP collector, e.g. http://localhost:4318
+func NewOTLPExporter(endpoint string, headers map[string]string, timeout time.Duration) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("OTLP endpoint must be http(s) URL, got %s", endpoint)
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, otlpTracesPath) + otlpTracesPath),
+		otlptracehttp.WithHeaders(headers),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if timeout > 0 {
+		options = append(options, otlptracehttp.WithTimeout(timeout))
+	}
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// fileExporter appends spans to file as JSON lines, it's for local debugging without collector
+type fileExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &fileExporter{exporter, file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	if err := e.Exporter.Shutdown(ctx); err != nil {
+		return err
+	}
+	return e.file.Close()
+}
@@ -0,0 +1,129 @@
+// Package tracing records spans of request processing stages with OpenTelemetry and propagates
+// W3C trace context, so that spans are linked to traces of callers.
+// Spans are no-op until Init is called, so call sites don't need to check if tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/DaoCasino/casino-backend"
+
+// Span is a stage being traced
+type Span struct {
+	span trace.Span
+}
+
+// TraceID returns hex encoded trace ID, empty if there is neither tracing nor incoming trace context
+func (s *Span) TraceID() string {
+	spanContext := s.span.SpanContext()
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// SpanID returns hex encoded span ID, empty if there is neither tracing nor incoming trace context
+func (s *Span) SpanID() string {
+	spanContext := s.span.SpanContext()
+	if !spanContext.HasSpanID() {
+		return ""
+	}
+	return spanContext.SpanID().String()
+}
+
+// SetAttribute sets string, bool, integer or float attribute, other values are formatted with %v
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(makeAttribute(key, value))
+}
+
+// SetError marks span as failed, nil error is ignored
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *Span) End() {
+	s.span.End()
+}
+
+func makeAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case uint64:
+		// OTLP has no unsigned integers, so larger values are kept as strings
+		if v > math.MaxInt64 {
+			return attribute.String(key, fmt.Sprint(v))
+		}
+		return attribute.Int64(key, int64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	default:
+		return attribute.String(key, fmt.Sprintf("%v", v))
+	}
+}
+
+// Start starts span, it's a child of span in ctx if any
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, name)
+	return ctx, &Span{span}
+}
+
+// Init sets provider used by Start and W3C trace context propagator, nil provider disables tracing
+func Init(provider *sdktrace.TracerProvider) {
+	if provider == nil {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	} else {
+		otel.SetTracerProvider(provider)
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Middleware continues trace of the caller given in `traceparent` header,
+// so spans of request handlers are children of the caller's span
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// NewProvider makes provider exporting spans in batches of batchSize at least every flushInterval
+func NewProvider(serviceName string, exporter sdktrace.SpanExporter, batchSize int,
+	flushInterval time.Duration) *sdktrace.TracerProvider {
+	options := []sdktrace.BatchSpanProcessorOption{sdktrace.WithBatchTimeout(flushInterval)}
+	if batchSize > 0 {
+		options = append(options, sdktrace.WithMaxExportBatchSize(batchSize))
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, options...),
+		sdktrace.WithResource(sdkresource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+}
@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNoopSpan(t *testing.T) {
+	assert := assert.New(t)
+	Init(nil)
+	_, span := Start(context.Background(), "noop")
+	assert.Equal("", span.TraceID())
+	assert.Equal("", span.SpanID())
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("error"))
+	span.End()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	assert := assert.New(t)
+	var requests []*collectortrace.ExportTraceServiceRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v1/traces", r.URL.Path)
+		assert.Equal("application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal("secret", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		req := new(collectortrace.ExportTraceServiceRequest)
+		assert.Nil(proto.Unmarshal(body, req))
+		requests = append(requests, req)
+	}))
+	defer collector.Close()
+
+	_, err := NewOTLPExporter("localhost:4318", nil, 0)
+	assert.NotNil(err)
+	exporter, err := NewOTLPExporter(collector.URL, map[string]string{"Authorization": "secret"}, time.Second)
+	assert.Nil(err)
+	provider := NewProvider("casino", exporter, 10, time.Hour)
+	Init(provider)
+	defer Init(nil)
+	ctx, root := Start(context.Background(), "root")
+	root.SetAttribute("session_id", uint64(42))
+	_, child := Start(ctx, "child")
+	child.SetError(errors.New("failed"))
+	child.End()
+	root.End()
+	assert.Nil(provider.Shutdown(context.Background()))
+
+	assert.Equal(1, len(requests))
+	resource := requests[0].ResourceSpans[0]
+	assert.Equal("service.name", resource.Resource.Attributes[0].Key)
+	assert.Equal("casino", resource.Resource.Attributes[0].Value.GetStringValue())
+	spans := resource.InstrumentationLibrarySpans[0].Spans
+	assert.Equal(2, len(spans))
+	assert.Equal("child", spans[0].Name)
+	assert.Equal(root.TraceID(), hex.EncodeToString(spans[0].TraceId))
+	assert.Equal(root.SpanID(), hex.EncodeToString(spans[0].ParentSpanId))
+	assert.Equal(tracepb.Status_STATUS_CODE_ERROR, spans[0].Status.Code)
+	assert.Equal("failed", spans[0].Status.Message)
+	assert.Empty(spans[1].ParentSpanId)
+	assert.Equal("session_id", spans[1].Attributes[0].Key)
+	assert.Equal(int64(42), spans[1].Attributes[0].Value.GetIntValue())
+}
+
+func TestFileExporter(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "tracing")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.jsonl")
+
+	exporter, err := NewFileExporter(path)
+	assert.Nil(err)
+	provider := NewProvider("casino", exporter, 1, time.Hour)
+	Init(provider)
+	defer Init(nil)
+	for _, name := range []string{"first", "second"} {
+		_, span := Start(context.Background(), name)
+		span.End()
+		assert.Nil(provider.ForceFlush(context.Background()))
+	}
+	assert.Nil(provider.Shutdown(context.Background()))
+
+	file, err := os.Open(path)
+	assert.Nil(err)
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span struct{ Name string }
+		assert.Nil(json.Unmarshal(scanner.Bytes(), &span))
+		names = append(names, span.Name)
+	}
+	assert.Equal([]string{"first", "second"}, names)
+}
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	var span *Span
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span = Start(r.Context(), "handler")
+		span.End()
+	}))
+	request := func() {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// trace ID of the caller is known even if tracing is disabled, so it can be logged
+	Init(nil)
+	request()
+	assert.Equal(traceID, span.TraceID())
+
+	recorder := tracetest.NewSpanRecorder()
+	Init(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer Init(nil)
+	request()
+	assert.Equal(traceID, span.TraceID())
+	assert.NotEqual(parentID, span.SpanID())
+	ended := recorder.Ended()
+	assert.Equal(1, len(ended))
+	assert.Equal(parentID, ended[0].Parent().SpanID().String())
+}
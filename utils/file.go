@@ -1,8 +1,6 @@
 package utils
 
 import (
-	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -14,56 +12,112 @@ import (
 	"strings"
 
 	"github.com/eoscanada/eos-go"
-	"github.com/rs/zerolog/log"
 )
 
-type FileStorage interface {
-	Read(p []byte) (n int, err error)
-	Write(b []byte) (n int, err error)
-	Truncate(size int64) error
-	Seek(offset int64, whence int) (ret int64, err error)
+// ReadSecretFile reads trimmed secret from file which must not be accessible by group or others
+func ReadSecretFile(filename string) (string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("secret file %s is accessible by group or others (mode %s)",
+			filename, info.Mode().Perm())
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
 }
 
-func ReadOffset(r FileStorage) (uint64, error) {
-	log.Debug().Msg("reading offset")
-	var offset uint64
-	_, err := fmt.Fscan(r, &offset)
-	return offset, err
+func ReadWIF(filename string) (string, error) {
+	return ReadSecretFile(filename)
 }
 
-func WriteOffset(w FileStorage, offset uint64) error {
-	log.Debug().Msgf("writing offset, value: %v", offset)
-	bs := []byte(strconv.Itoa(int(offset)))
-	if err := w.Truncate(0); err != nil {
-		return err
+func ReadRsa(base64Rsa string) (*rsa.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(base64Rsa)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := w.Seek(0, 0); err != nil {
-		return err
+	return ParseRsaPrivateKey(data)
+}
+
+// EncodeRsa encodes private key as base64 encoded PEM read by ReadRsa
+func EncodeRsa(key *rsa.PrivateKey) string {
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// ParseRsaPrivateKey parses PEM encoded PKCS#1 private key
+func ParseRsaPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("RSA private key isn't PEM encoded")
 	}
-	_, err := w.Write(bs)
-	return err
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
 }
 
-func ReadWIF(filename string) string {
-	content, err := ioutil.ReadFile(filename)
+// ReadRsaFile reads RSA private key stored as PEM or base64 encoded PEM
+func ReadRsaFile(filename string) (*rsa.PrivateKey, error) {
+	content, err := ReadSecretFile(filename)
 	if err != nil {
-		log.Panic().Msg(err.Error())
+		return nil, err
 	}
-	wif := strings.TrimSpace(strings.TrimSuffix(string(content), "\n"))
-	return wif
+	if block, _ := pem.Decode([]byte(content)); block != nil {
+		return ParseRsaPrivateKey([]byte(content))
+	}
+	return ReadRsa(content)
 }
 
-func ReadRsa(base64Rsa string) (*rsa.PrivateKey, error) {
-	data, err := base64.StdEncoding.DecodeString(base64Rsa)
+// ParseRsaPublicKey accepts public key as PEM, base64 encoded PEM or base64 encoded DER (PKIX or PKCS#1)
+func ParseRsaPublicKey(encoded string) (*rsa.PublicKey, error) {
+	data := []byte(strings.TrimSpace(encoded))
+	if block, _ := pem.Decode(data); block == nil {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	if key, err := x509.ParsePKCS1PublicKey(data); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(data)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(data)
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key isn't RSA one")
+	}
+	return rsaKey, nil
+}
+
+// EncodeRsaPublicKeyPEM encodes public key as PKIX PEM
+func EncodeRsaPublicKeyPEM(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// EncodeRsaPublicKey encodes public key as base64 encoded PKIX DER
+func EncodeRsaPublicKey(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
 	}
-	return key, err
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// RsaPublicKeysEqual compares RSA public keys by modulus and exponent
+func RsaPublicKeysEqual(a, b *rsa.PublicKey) bool {
+	return a.E == b.E && a.N.Cmp(b.N) == 0
 }
 
 func GetConfigPath(envVar, defaultValue string) string {
@@ -79,11 +133,20 @@ func GetAddr(port int) string {
 }
 
 func RsaSign(digest eos.Checksum256, key *rsa.PrivateKey) (string, error) {
-	sign, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	sign, err := DefaultRsaScheme.Sign(digest, key)
 	if err != nil {
 		return "", err
 	}
 
 	// contract requires base64 string
-	return base64.StdEncoding.EncodeToString(sign), nil
+	return DefaultRsaScheme.Encode(sign), nil
+}
+
+// RsaVerify verifies base64 encoded signature made by RsaSign
+func RsaVerify(digest eos.Checksum256, signature string, key *rsa.PublicKey) error {
+	sign, err := DefaultRsaScheme.Decode(signature)
+	if err != nil {
+		return err
+	}
+	return DefaultRsaScheme.Verify(digest, sign, key)
 }
@@ -0,0 +1,111 @@
+// Package retry repeats failed calls with exponential backoff until attempts are exhausted or context is done.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const defaultMultiplier = 2
+
+// PermanentError wraps an error which makes retries pointless
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent marks error as non retryable, Do returns wrapped error immediately
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{err}
+}
+
+// Observer is called after every failed attempt, delay is zero if no more attempts follow
+type Observer func(attempt int, err error, delay time.Duration)
+
+type Policy struct {
+	Attempts       int           // max attempts including the first one, 0 or less means a single attempt
+	InitialDelay   time.Duration // delay after the first failed attempt
+	MaxDelay       time.Duration // 0 means unlimited
+	Multiplier     float64       // delay growth per attempt, 2 if not set
+	Jitter         float64       // fraction of delay randomized, from 0 to 1
+	AttemptTimeout time.Duration // deadline of ctx passed to every attempt, 0 means no deadline
+	// Retryable tells if error is worth another attempt, nil means any error except PermanentError
+	Retryable func(err error) bool
+	Observer  Observer
+}
+
+// Delay returns backoff after attempt failed, attempts are counted from 1
+func (p *Policy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultMultiplier
+	}
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt && (p.MaxDelay == 0 || delay < float64(p.MaxDelay)); i++ {
+		delay *= multiplier
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * p.Jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+func (p *Policy) attempt(ctx context.Context, f func(ctx context.Context) error) error {
+	if p.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		defer cancel()
+	}
+	return f(ctx)
+}
+
+func (p *Policy) shouldRetry(ctx context.Context, attempt int, err error) bool {
+	if _, ok := err.(*PermanentError); ok {
+		return false
+	}
+	if ctx.Err() != nil || attempt >= p.Attempts {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Do calls f until it succeeds, returns the last error if retries are stopped.
+// f must return once its ctx is done, as it's never abandoned.
+func Do(ctx context.Context, policy Policy, f func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := policy.attempt(ctx, f)
+		if err == nil {
+			return nil
+		}
+		if !policy.shouldRetry(ctx, attempt, err) {
+			if permanent, ok := err.(*PermanentError); ok {
+				err = permanent.Err
+			}
+			if policy.Observer != nil {
+				policy.Observer(attempt, err, 0)
+			}
+			return err
+		}
+		delay := policy.Delay(attempt)
+		if policy.Observer != nil {
+			policy.Observer(attempt, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
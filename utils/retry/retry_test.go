@@ -0,0 +1,115 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func failer(times int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if times == 0 {
+			return nil
+		}
+		times--
+		return fmt.Errorf("fail amount is more than zero")
+	}
+}
+
+func TestDo(t *testing.T) {
+	assert := assert.New(t)
+	policy := Policy{Attempts: 3, InitialDelay: time.Millisecond}
+	err := Do(context.Background(), policy, failer(3))
+	assert.NotNil(err)
+	assert.Equal("fail amount is more than zero", err.Error())
+
+	policy.Attempts = 4
+	assert.Nil(Do(context.Background(), policy, failer(3)))
+
+	// no retries if attempts aren't set
+	calls := 0
+	err = Do(context.Background(), Policy{InitialDelay: time.Millisecond}, func(ctx context.Context) error {
+		calls++
+		return fmt.Errorf("failed")
+	})
+	assert.NotNil(err)
+	assert.Equal(1, calls)
+}
+
+func TestDoAttemptTimeout(t *testing.T) {
+	assert := assert.New(t)
+	slow := func(times int) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			if times == 0 {
+				return nil
+			}
+			times--
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+	policy := Policy{Attempts: 3, InitialDelay: time.Millisecond, AttemptTimeout: time.Millisecond}
+	assert.Equal(context.DeadlineExceeded, Do(context.Background(), policy, slow(3)))
+	policy.Attempts = 4
+	assert.Nil(Do(context.Background(), policy, slow(3)))
+}
+
+func TestDoPermanent(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	fatal := func(ctx context.Context) error {
+		calls++
+		return Permanent(fmt.Errorf("fatal"))
+	}
+	err := Do(context.Background(), Policy{Attempts: 3}, fatal)
+	assert.Equal("fatal", err.Error())
+	assert.Equal(1, calls)
+
+	// predicate stops retries as well
+	errFatal := errors.New("fatal")
+	calls = 0
+	err = Do(context.Background(), Policy{Attempts: 3, Retryable: func(err error) bool { return err != errFatal }},
+		func(ctx context.Context) error {
+			calls++
+			return errFatal
+		})
+	assert.Equal(errFatal, err)
+	assert.Equal(1, calls)
+}
+
+func TestDoCancel(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var attempts []int
+	start := time.Now()
+	err := Do(ctx, Policy{Attempts: 10, InitialDelay: time.Hour, Observer: func(attempt int, err error, delay time.Duration) {
+		attempts = append(attempts, attempt)
+	}}, failer(-1))
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+	assert.Equal([]int{1}, attempts)
+}
+
+func TestDelay(t *testing.T) {
+	assert := assert.New(t)
+	policy := Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	assert.Equal(time.Second, policy.Delay(1))
+	assert.Equal(2*time.Second, policy.Delay(2))
+	assert.Equal(4*time.Second, policy.Delay(3))
+	assert.Equal(5*time.Second, policy.Delay(4))
+	assert.Equal(5*time.Second, policy.Delay(100))
+
+	policy.Multiplier = 3
+	assert.Equal(3*time.Second, policy.Delay(2))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(1)
+		assert.True(delay > time.Second/2 && delay <= time.Second, delay.String())
+	}
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // register hashes usable in schemes
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	RsaPaddingPKCS1v15 = "pkcs1v15"
+	RsaPaddingPSS      = "pss"
+
+	SignatureEncodingBase64    = "base64"
+	SignatureEncodingBase64URL = "base64url"
+	SignatureEncodingHex       = "hex"
+)
+
+var rsaHashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// RsaScheme describes how digest is signed and how signature is encoded
+type RsaScheme struct {
+	Padding  string
+	Hash     crypto.Hash
+	Encoding string
+	// HashDigest makes digest be hashed with Hash before signing,
+	// otherwise digest is signed as is and its size must match Hash
+	HashDigest bool
+}
+
+// DefaultRsaScheme is the scheme expected by existing game contracts
+var DefaultRsaScheme = RsaScheme{RsaPaddingPKCS1v15, crypto.SHA256, SignatureEncodingBase64, false}
+
+// ParseRsaScheme makes scheme from its names, empty names are set to default ones
+func ParseRsaScheme(padding, hash, encoding string, hashDigest bool) (RsaScheme, error) {
+	scheme := DefaultRsaScheme
+	scheme.HashDigest = hashDigest
+	if padding != "" {
+		scheme.Padding = strings.ToLower(padding)
+	}
+	if encoding != "" {
+		scheme.Encoding = strings.ToLower(encoding)
+	}
+	if hash != "" {
+		var ok bool
+		if scheme.Hash, ok = rsaHashes[strings.ToLower(hash)]; !ok {
+			return RsaScheme{}, fmt.Errorf("unknown hash: %s", hash)
+		}
+	}
+	switch scheme.Padding {
+	case RsaPaddingPKCS1v15, RsaPaddingPSS:
+	default:
+		return RsaScheme{}, fmt.Errorf("unknown padding: %s", padding)
+	}
+	switch scheme.Encoding {
+	case SignatureEncodingBase64, SignatureEncodingBase64URL, SignatureEncodingHex:
+	default:
+		return RsaScheme{}, fmt.Errorf("unknown signature encoding: %s", encoding)
+	}
+	return scheme, nil
+}
+
+func (s RsaScheme) String() string {
+	hash := ""
+	for name, h := range rsaHashes {
+		if h == s.Hash {
+			hash = name
+		}
+	}
+	if s.HashDigest {
+		hash += "(digest)"
+	}
+	return fmt.Sprintf("%s-%s-%s", s.Padding, hash, s.Encoding)
+}
+
+func (s RsaScheme) hashed(digest []byte) ([]byte, error) {
+	if s.HashDigest {
+		h := s.Hash.New()
+		h.Write(digest)
+		return h.Sum(nil), nil
+	}
+	if len(digest) != s.Hash.Size() {
+		return nil, fmt.Errorf("digest size %d doesn't match hash size %d", len(digest), s.Hash.Size())
+	}
+	return digest, nil
+}
+
+func (s RsaScheme) pssOptions() *rsa.PSSOptions {
+	return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: s.Hash}
+}
+
+// Sign returns raw signature of digest, use Encode to get the form expected by contract
+func (s RsaScheme) Sign(digest []byte, key *rsa.PrivateKey) ([]byte, error) {
+	hashed, err := s.hashed(digest)
+	if err != nil {
+		return nil, err
+	}
+	if s.Padding == RsaPaddingPSS {
+		return rsa.SignPSS(rand.Reader, key, s.Hash, hashed, s.pssOptions())
+	}
+	return rsa.SignPKCS1v15(rand.Reader, key, s.Hash, hashed)
+}
+
+// Verify checks raw signature of digest
+func (s RsaScheme) Verify(digest []byte, sign []byte, key *rsa.PublicKey) error {
+	hashed, err := s.hashed(digest)
+	if err != nil {
+		return err
+	}
+	if s.Padding == RsaPaddingPSS {
+		return rsa.VerifyPSS(key, s.Hash, hashed, sign, s.pssOptions())
+	}
+	return rsa.VerifyPKCS1v15(key, s.Hash, hashed, sign)
+}
+
+func (s RsaScheme) Encode(sign []byte) string {
+	switch s.Encoding {
+	case SignatureEncodingHex:
+		return hex.EncodeToString(sign)
+	case SignatureEncodingBase64URL:
+		return base64.URLEncoding.EncodeToString(sign)
+	default:
+		return base64.StdEncoding.EncodeToString(sign)
+	}
+}
+
+func (s RsaScheme) Decode(signature string) ([]byte, error) {
+	switch s.Encoding {
+	case SignatureEncodingHex:
+		return hex.DecodeString(signature)
+	case SignatureEncodingBase64URL:
+		return base64.URLEncoding.DecodeString(signature)
+	default:
+		return base64.StdEncoding.DecodeString(signature)
+	}
+}